@@ -0,0 +1,125 @@
+package chores
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	The group's activity feed, the "what's been happening" screen. It is
+	the same groups/{groupId}/activity collection the Group function writes
+	joins and invites to and serves from GET /groups/{groupId}/activity:
+
+	/groups/{groupId}/activity/{entryId}
+		type, actor, at,
+		chore_id, chore_name, history_id (chore entries),
+		member (chore_reassigned: the new assignee), assignees,
+		part, verified_by (chore_completed),
+		reason (chore_reassigned: "away", or the action when it wasn't a
+		plain reassign: "claimed", "unclaimed", "auto_assigned", "swapped")
+
+	This function adds chore_created (new chores, templates and imports),
+	chore_completed, chore_reassigned and chore_deleted. History keeps the
+	full detail; the feed only has enough to show a line and link to the
+	chore.
+*/
+
+// activityTypes maps the history actions that show up in the feed to
+// their activity type. Anything that changes who has the chore counts as
+// a reassignment.
+var activityTypes = map[string]string{
+	"completed":     "chore_completed",
+	"reassigned":    "chore_reassigned",
+	"claimed":       "chore_reassigned",
+	"unclaimed":     "chore_reassigned",
+	"auto_assigned": "chore_reassigned",
+	"swapped":       "chore_reassigned",
+}
+
+// activityDoc fills in the fields every activity entry has.
+func activityDoc(typ string, actor string, fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	fields["type"] = typ
+	fields["actor"] = actor
+	fields["at"] = firestore.ServerTimestamp
+	return fields
+}
+
+// recordActivity adds an entry outside a transaction. Like notifications,
+// a missing feed entry never fails the action, so errors are only logged.
+func recordActivity(ctx context.Context, groupRef *firestore.DocumentRef, typ string, actor string, fields map[string]interface{}) {
+	if _, _, err := groupRef.Collection("activity").Add(ctx, activityDoc(typ, actor, fields)); err != nil {
+		log.Printf("Failed to record %s activity in group %s: %v", typ, groupRef.ID, err)
+	}
+}
+
+// recordActivities adds one entry of the same type per fields, for actions
+// that create many chores at once (templates, imports). Errors are only
+// logged, as for recordActivity.
+func recordActivities(ctx context.Context, groupRef *firestore.DocumentRef, typ string, actor string, entries []map[string]interface{}) {
+	if len(entries) == 0 {
+		return
+	}
+	bw := firestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(entries))
+	for _, fields := range entries {
+		job, err := bw.Create(groupRef.Collection("activity").NewDoc(), activityDoc(typ, actor, fields))
+		if err != nil {
+			log.Printf("Failed to queue %s activity in group %s: %v", typ, groupRef.ID, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			log.Printf("Failed to record %s activity in group %s: %v", typ, groupRef.ID, err)
+		}
+	}
+}
+
+// recordChoreActivity adds the feed entry for a history event, if its
+// action has one. It only writes, so it can go at the end of the
+// transaction that wrote the event.
+func recordChoreActivity(tx *firestore.Transaction, chore *firestore.DocumentSnapshot, historyRef *firestore.DocumentRef, uid string, action string, event map[string]interface{}) error {
+	typ, ok := activityTypes[action]
+	if !ok {
+		return nil
+	}
+	name, _ := chore.Data()["chore_name"].(string)
+	fields := map[string]interface{}{
+		"chore_id":   chore.Ref.ID,
+		"chore_name": name,
+		"history_id": historyRef.ID,
+	}
+	switch typ {
+	case "chore_completed":
+		if part, _ := event["part"].(bool); part {
+			fields["part"] = true
+		}
+	case "chore_reassigned":
+		if action == "claimed" {
+			fields["member"] = uid
+		} else if to, _ := event["to_assignee"].(string); to != "" {
+			fields["member"] = to
+		}
+		if to, ok := event["to_assignees"]; ok {
+			fields["assignees"] = to
+		}
+		if action != "reassigned" {
+			fields["reason"] = action
+		}
+	}
+	for _, key := range []string{"reason", "verified_by"} {
+		if v, _ := event[key].(string); v != "" {
+			fields[key] = v
+		}
+	}
+	groupRef := chore.Ref.Parent.Parent
+	return tx.Create(groupRef.Collection("activity").NewDoc(), activityDoc(typ, uid, fields))
+}
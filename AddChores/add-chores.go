@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"testing"
 	"net/http"
 	"context"
 	"encoding/json"
+	"errors"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
@@ -70,6 +73,14 @@ func AddChoreHandler(w http.ResponseWriter, r *http.Request) {
 		ChoreDueDate		string	`json:"chore_due_date"`
 		ChoreFrequency		string	`json:"chore_frequency"`
 		ChoreAssignee		string	`json:"chore_assignee"`
+		ChoreAssignees		[]string	`json:"chore_assignees"`	// optional, for chores shared between members
+		CompletionPolicy	string	`json:"completion_policy"`	// optional, "any" (default) or "all"
+		Priority			int		`json:"priority"`			// optional, 0 (none) to 3 (high)
+		Tags				[]string	`json:"tags"`			// optional, from the group's tag vocabulary
+		EstimatedMinutes	int		`json:"estimated_minutes"`	// optional, used for workload stats
+		ClaimDeadline		string	`json:"claim_deadline"`		// optional, RFC 3339; unassigned chores get auto-assigned after it
+		RequiresVerification	*bool	`json:"requires_verification"`	// optional, overrides the group's settings.require_verification
+		Points				int		`json:"points"`			// optional, 0 works it out from estimated_minutes
 		// ChoreStatus			string	`json:"chore_status"`
 	}
 
@@ -85,6 +96,87 @@ func AddChoreHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 
+	dueDate, err := time.Parse(dateLayout, RequestBody.ChoreDueDate)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid chore_due_date %q; use YYYY-MM-DD", RequestBody.ChoreDueDate), http.StatusBadRequest)
+		return
+	}
+
+	if !validFrequency(RequestBody.ChoreFrequency) {
+		http.Error(w, fmt.Sprintf("Invalid chore_frequency %q; use daily, weekly, monthly or once", RequestBody.ChoreFrequency), http.StatusBadRequest)
+		return
+	}
+
+	if RequestBody.EstimatedMinutes < 0 || RequestBody.EstimatedMinutes > maxEstimatedMinutes {
+		http.Error(w, fmt.Sprintf("estimated_minutes must be between 0 and %d", maxEstimatedMinutes), http.StatusBadRequest)
+		return
+	}
+
+	if !validPoints(RequestBody.Points) {
+		http.Error(w, fmt.Sprintf("points must be between 0 and %d", maxChorePoints), http.StatusBadRequest)
+		return
+	}
+
+	if !validPriority(RequestBody.Priority) {
+		http.Error(w, fmt.Sprintf("priority must be between 0 and %d", maxPriority), http.StatusBadRequest)
+		return
+	}
+
+	// Only the group's owner and members can add chores to it
+	groupSnap, err := requireGroupMember(ctx, RequestBody.GroupID, RequestBody.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create chore: %v", err), statusForError(err))
+		return
+	}
+
+	tags, err := checkTags(ctx, groupSnap.Ref, RequestBody.Tags)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errBadTags) {
+			code = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	// chore_assignee and chore_assignees are merged; the first one stays
+	// in chore_assignee. Everyone on it has to be in the group.
+	assignees, err := checkAssignees(ctx, groupSnap, append([]string{RequestBody.ChoreAssignee}, RequestBody.ChoreAssignees...))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errBadAssignees) {
+			code = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	primary := ""
+	if len(assignees) > 0 {
+		primary = assignees[0]
+	}
+	if !validPolicy(RequestBody.CompletionPolicy) {
+		http.Error(w, fmt.Sprintf("Invalid completion_policy %q; use any or all", RequestBody.CompletionPolicy), http.StatusBadRequest)
+		return
+	}
+	policy := RequestBody.CompletionPolicy
+	if policy == "" {
+		policy = policyAny
+	}
+
+	var claimDeadline time.Time
+	if RequestBody.ClaimDeadline != "" {
+		if primary != "" {
+			http.Error(w, "claim_deadline is only for chores without a chore_assignee", http.StatusBadRequest)
+			return
+		}
+		t, err := time.Parse(time.RFC3339, RequestBody.ClaimDeadline)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid claim_deadline %q; use RFC 3339", RequestBody.ClaimDeadline), http.StatusBadRequest)
+			return
+		}
+		claimDeadline = t
+	}
+
 	choreInfo := map[string]interface{}{
 		"created_at" 		: firestore.ServerTimestamp,
 		"group_id"			: RequestBody.GroupID,
@@ -92,11 +184,26 @@ func AddChoreHandler(w http.ResponseWriter, r *http.Request) {
 		"chore_details"		: RequestBody.ChoreDetails,
 		"chore_due_date"	: RequestBody.ChoreDueDate,
 		"chore_frequency"	: RequestBody.ChoreFrequency,
-		"chore_assignee"	: RequestBody.ChoreAssignee,
+		"schedule_anchor"	: RequestBody.ChoreDueDate,	// where repeats are counted from
+		"schedule_day"		: dueDate.Day(),
+		"chore_assignee"	: primary,
+		"assignees"			: assignees,
+		"completion_policy"	: policy,
+		"completed_by_members"	: []string{},
+		"estimated_minutes"	: RequestBody.EstimatedMinutes,
+		"priority"			: RequestBody.Priority,
+		"points"			: RequestBody.Points,
+		"tags"				: tags,
 		"created_by"		: RequestBody.UserID,
 		"chore_status"		: "not started",
 		"completed_at"		: "NA",
 	}
+	if !claimDeadline.IsZero() {
+		choreInfo["claim_deadline"] = claimDeadline
+	}
+	if RequestBody.RequiresVerification != nil {
+		choreInfo["requires_verification"] = *RequestBody.RequiresVerification
+	}
 
 
 	docID, err := saveChoreToFirestore(ctx, firestoreClient, RequestBody.GroupID, choreInfo)
@@ -105,21 +212,33 @@ func AddChoreHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordActivity(ctx, groupSnap.Ref, "chore_created", RequestBody.UserID, map[string]interface{}{
+		"chore_id":		docID,
+		"chore_name":	RequestBody.ChoreName,
+		"assignees":	assignees,
+	})
+
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, `{"message": "Group %s created successfully"}`, docID)
 }
 
 func init() {
+	functions.HTTP("AddChoreHandler", AddChoreHandler)
+	functions.HTTP("ChoreHandler", ChoreHandler)
+	if testing.Testing() {
+		// The unit tests only cover functions that don't touch Firestore
+		return
+	}
+
 	ctx := context.Background()
-    projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	// Deployed functions can find their project without the variable
+	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	if projectID == "" {
+		projectID = firestore.DetectProjectID
+	}
 	var err error
 	firestoreClient, err = firestore.NewClient(ctx, projectID)
 	if err != nil {
 		log.Fatalf("Failed to initialize Firestore client: %v", err)
-	}	
-	functions.HTTP("AddChoreHandler", AddChoreHandler)
+	}
 }
-
-
-
-
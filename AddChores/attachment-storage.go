@@ -0,0 +1,330 @@
+package chores
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+/*
+	Where attachment files live. Which backend is used comes from the
+	environment:
+
+	ATTACHMENT_STORAGE   gcs | local   (required; local is for development)
+
+	gcs signs V4 URLs for ATTACHMENT_BUCKET, so uploads and downloads go
+	straight to Cloud Storage. local keeps files under ATTACHMENT_DIR
+	(default a temp directory) and serves them from ChoreHandler itself
+	at /attachments/local/..., with HMAC signed URLs so it behaves like
+	the real thing in dev and tests. ATTACHMENT_LOCAL_BASE is the URL the
+	function is reachable on (default http://localhost:8080) and
+	ATTACHMENT_LOCAL_SECRET the signing key (random per process if unset).
+*/
+
+var errObjectNotFound = errors.New("attachment file not found")
+
+// objectInfo is what the store knows about an uploaded file.
+type objectInfo struct {
+	Size        int64
+	ContentType string
+}
+
+// uploadTarget is a signed URL plus the headers the upload has to send.
+type uploadTarget struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+}
+
+// attachmentStore hands out signed URLs for one storage backend.
+type attachmentStore interface {
+	UploadURL(ctx context.Context, object string, contentType string, maxSize int64, expires time.Time) (uploadTarget, error)
+	DownloadURL(ctx context.Context, object string, expires time.Time) (string, error)
+	Stat(ctx context.Context, object string) (objectInfo, error)
+	Delete(ctx context.Context, object string) error
+}
+
+var (
+	storeOnce sync.Once
+	store     attachmentStore
+	storeErr  error
+)
+
+// attachmentStorage returns the configured backend.
+func attachmentStorage(ctx context.Context) (attachmentStore, error) {
+	storeOnce.Do(func() {
+		switch kind := os.Getenv("ATTACHMENT_STORAGE"); kind {
+		case "":
+			// Not defaulting to local: it would keep uploads in one
+			// instance's /tmp and hand out localhost links
+			storeErr = fmt.Errorf("ATTACHMENT_STORAGE must be set (gcs, or local for development)")
+		case "local":
+			store, storeErr = newLocalStore()
+		case "gcs":
+			store, storeErr = newGCSStore(ctx)
+		default:
+			storeErr = fmt.Errorf("unknown ATTACHMENT_STORAGE %q", kind)
+		}
+	})
+	return store, storeErr
+}
+
+// gcsStore signs URLs for a Cloud Storage bucket.
+type gcsStore struct {
+	bucket *storage.BucketHandle
+}
+
+func newGCSStore(ctx context.Context) (*gcsStore, error) {
+	name := os.Getenv("ATTACHMENT_BUCKET")
+	if name == "" {
+		return nil, fmt.Errorf("ATTACHMENT_BUCKET must be set")
+	}
+	client, err := storage.NewClient(context.WithoutCancel(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	return &gcsStore{bucket: client.Bucket(name)}, nil
+}
+
+func (g *gcsStore) UploadURL(ctx context.Context, object string, contentType string, maxSize int64, expires time.Time) (uploadTarget, error) {
+	sizeRange := fmt.Sprintf("0,%d", maxSize)
+	u, err := g.bucket.SignedURL(object, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      http.MethodPut,
+		ContentType: contentType,
+		Headers:     []string{"x-goog-content-length-range:" + sizeRange},
+		Expires:     expires,
+	})
+	if err != nil {
+		return uploadTarget{}, fmt.Errorf("failed to sign upload URL: %w", err)
+	}
+	return uploadTarget{
+		URL:    u,
+		Method: http.MethodPut,
+		Headers: map[string]string{
+			"Content-Type":                contentType,
+			"x-goog-content-length-range": sizeRange,
+		},
+	}, nil
+}
+
+func (g *gcsStore) DownloadURL(ctx context.Context, object string, expires time.Time) (string, error) {
+	u, err := g.bucket.SignedURL(object, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  http.MethodGet,
+		Expires: expires,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to sign download URL: %w", err)
+	}
+	return u, nil
+}
+
+func (g *gcsStore) Stat(ctx context.Context, object string) (objectInfo, error) {
+	attrs, err := g.bucket.Object(object).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return objectInfo{}, errObjectNotFound
+	}
+	if err != nil {
+		return objectInfo{}, err
+	}
+	return objectInfo{Size: attrs.Size, ContentType: attrs.ContentType}, nil
+}
+
+func (g *gcsStore) Delete(ctx context.Context, object string) error {
+	err := g.bucket.Object(object).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}
+
+// localStore keeps files on disk and serves them through ChoreHandler.
+type localStore struct {
+	dir    string
+	base   string
+	secret []byte
+}
+
+func newLocalStore() (*localStore, error) {
+	dir := os.Getenv("ATTACHMENT_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "chore-attachments")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create ATTACHMENT_DIR: %w", err)
+	}
+	base := os.Getenv("ATTACHMENT_LOCAL_BASE")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	secret := []byte(os.Getenv("ATTACHMENT_LOCAL_SECRET"))
+	if len(secret) == 0 {
+		// Fine for one dev server; set it when several instances share a dir
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &localStore{dir: dir, base: strings.TrimSuffix(base, "/"), secret: secret}, nil
+}
+
+// sign is the signature for one method on one object until expires.
+func (l *localStore) sign(method string, object string, expires int64, extra string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", method, object, expires, extra)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *localStore) signedURL(method string, object string, expires time.Time, extra url.Values) string {
+	q := url.Values{}
+	for k, v := range extra {
+		q[k] = v
+	}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", l.sign(method, object, expires.Unix(), extra.Encode()))
+	return l.base + "/attachments/local/" + object + "?" + q.Encode()
+}
+
+func (l *localStore) UploadURL(ctx context.Context, object string, contentType string, maxSize int64, expires time.Time) (uploadTarget, error) {
+	extra := url.Values{"content_type": {contentType}, "max_size": {strconv.FormatInt(maxSize, 10)}}
+	return uploadTarget{
+		URL:     l.signedURL(http.MethodPut, object, expires, extra),
+		Method:  http.MethodPut,
+		Headers: map[string]string{"Content-Type": contentType},
+	}, nil
+}
+
+func (l *localStore) DownloadURL(ctx context.Context, object string, expires time.Time) (string, error) {
+	return l.signedURL(http.MethodGet, object, expires, url.Values{}), nil
+}
+
+// path maps an object name into dir, refusing anything that escapes it.
+func (l *localStore) path(object string) (string, error) {
+	p := filepath.Join(l.dir, filepath.FromSlash(object))
+	if !strings.HasPrefix(p, filepath.Clean(l.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %q", object)
+	}
+	return p, nil
+}
+
+func (l *localStore) Stat(ctx context.Context, object string) (objectInfo, error) {
+	p, err := l.path(object)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return objectInfo{}, errObjectNotFound
+	}
+	if err != nil {
+		return objectInfo{}, err
+	}
+	contentType, _ := os.ReadFile(p + ".type")
+	return objectInfo{Size: fi.Size(), ContentType: string(contentType)}, nil
+}
+
+func (l *localStore) Delete(ctx context.Context, object string) error {
+	p, err := l.path(object)
+	if err != nil {
+		return err
+	}
+	for _, f := range []string{p, p + ".type"} {
+		if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// localObjectHandler is the local backend's stand in for Cloud Storage: it
+// takes uploads and serves downloads for URLs signed by localStore.
+//
+//	PUT /attachments/local/{object...}?expires=...&sig=...
+//	GET /attachments/local/{object...}?expires=...&sig=...
+func localObjectHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	s, err := attachmentStorage(ctx)
+	l, ok := s.(*localStore)
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return fmt.Errorf("local attachment storage is not enabled")
+	}
+	object := r.PathValue("object")
+	q := r.URL.Query()
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+
+	extra := url.Values{}
+	if r.Method == http.MethodPut {
+		extra.Set("content_type", q.Get("content_type"))
+		extra.Set("max_size", q.Get("max_size"))
+	}
+	want := l.sign(r.Method, object, expires, extra.Encode())
+	if !hmac.Equal([]byte(want), []byte(q.Get("sig"))) || time.Now().Unix() > expires {
+		http.Error(w, "Invalid or expired signature", http.StatusForbidden)
+		return fmt.Errorf("bad signature for %s", object)
+	}
+	p, err := l.path(object)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	if r.Method == http.MethodGet {
+		contentType, _ := os.ReadFile(p + ".type")
+		if len(contentType) > 0 {
+			w.Header().Set("Content-Type", string(contentType))
+		}
+		http.ServeFile(w, r, p)
+		return nil
+	}
+
+	if r.Header.Get("Content-Type") != q.Get("content_type") {
+		http.Error(w, "Content-Type doesn't match the signed upload", http.StatusBadRequest)
+		return fmt.Errorf("content type mismatch")
+	}
+	maxSize, _ := strconv.ParseInt(q.Get("max_size"), 10, 64)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return err
+	}
+	// Write next to the target and rename, so a failed upload leaves any
+	// earlier one alone
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return err
+	}
+	_, err = io.Copy(f, http.MaxBytesReader(w, r.Body, maxSize))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		http.Error(w, fmt.Sprintf("Failed to store file: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if err := os.WriteFile(p+".type", []byte(q.Get("content_type")), 0o644); err != nil {
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
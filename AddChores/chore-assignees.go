@@ -0,0 +1,251 @@
+package chores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	Shared chores: a chore can be assigned to several members.
+
+	assignees holds all of them, so "chores assigned to me" is an
+	array-contains query, and chore_assignee keeps the first one so
+	anything reading the single field still works. Both are always
+	written together (see assigneeUpdates).
+
+	completion_policy says when a shared chore is done:
+		"any" (default)	the first member to complete it closes it
+		"all"			each assignee completes their part; the chore moves
+						on once everyone has, tracked in completed_by_members
+*/
+
+const maxAssignees = 10
+
+const (
+	policyAny = "any"
+	policyAll = "all"
+)
+
+var (
+	errNotAssignee  = errors.New("only the chore's assignees can complete their part")
+	errAlreadyDone  = errors.New("you already completed your part of this chore")
+	errBadAssignees = errors.New("invalid assignees")
+)
+
+// choreAssignees reads assignees, falling back to chore_assignee for
+// chores created before there could be more than one.
+func choreAssignees(chore map[string]interface{}) []string {
+	if list := stringList(chore["assignees"]); len(list) > 0 {
+		return list
+	}
+	if a, _ := chore["chore_assignee"].(string); a != "" {
+		return []string{a}
+	}
+	return []string{}
+}
+
+// completionPolicy is the chore's completion_policy, policyAny if unset.
+func completionPolicy(chore map[string]interface{}) string {
+	if p, _ := chore["completion_policy"].(string); p == policyAll {
+		return policyAll
+	}
+	return policyAny
+}
+
+func validPolicy(p string) bool {
+	return p == "" || p == policyAny || p == policyAll
+}
+
+// stringList reads a Firestore array of strings.
+func stringList(v interface{}) []string {
+	items, _ := v.([]interface{})
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, _ := item.(string); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanAssignees drops blanks and duplicates, keeping the order, and
+// enforces maxAssignees.
+func cleanAssignees(ids []string) ([]string, error) {
+	list := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != "" && !containsString(list, id) {
+			list = append(list, id)
+		}
+	}
+	if len(list) > maxAssignees {
+		return nil, fmt.Errorf("%w: a chore can have at most %d assignees", errBadAssignees, maxAssignees)
+	}
+	return list, nil
+}
+
+// checkAssignees cleans ids and checks every one of them is in the group.
+func checkAssignees(ctx context.Context, groupSnap *firestore.DocumentSnapshot, ids []string) ([]string, error) {
+	list, err := cleanAssignees(ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, uid := range list {
+		ok, err := isGroupMember(ctx, groupSnap, uid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check assignee %s: %w", uid, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a member of this group", errBadAssignees, uid)
+		}
+	}
+	return list, nil
+}
+
+// assigneeUpdates sets assignees and chore_assignee together. Parts of an
+// "all" chore already done by members who stay assigned are kept.
+func assigneeUpdates(chore map[string]interface{}, assignees []string) []firestore.Update {
+	primary := ""
+	if len(assignees) > 0 {
+		primary = assignees[0]
+	}
+	done := []string{}
+	for _, uid := range stringList(chore["completed_by_members"]) {
+		if containsString(assignees, uid) {
+			done = append(done, uid)
+		}
+	}
+	return []firestore.Update{
+		{Path: "assignees", Value: assignees},
+		{Path: "chore_assignee", Value: primary},
+		{Path: "completed_by_members", Value: done},
+	}
+}
+
+// remainingAssignees is who still has to do their part of an "all" chore.
+func remainingAssignees(assignees []string, done []string) []string {
+	remaining := []string{}
+	for _, uid := range assignees {
+		if !containsString(done, uid) {
+			remaining = append(remaining, uid)
+		}
+	}
+	return remaining
+}
+
+// myChoresHandler lists the open chores assigned to the caller, in one
+// group or across all of them, soonest due first. Across groups, chores
+// from deleted groups and groups the caller has left are left out.
+//
+//	GET /chores/mine?user_id=...
+//	GET /groups/{groupId}/chores/mine?user_id=...
+func myChoresHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	var shared, single firestore.Query
+	if groupID != "" {
+		groupSnap, err := requireGroupMember(ctx, groupID, userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list chores: %v", err), statusForError(err))
+			return err
+		}
+		col := groupSnap.Ref.Collection("chores")
+		shared = col.Where("assignees", "array-contains", userID)
+		single = col.Where("chore_assignee", "==", userID)
+	} else {
+		shared = firestoreClient.CollectionGroup("chores").Where("assignees", "array-contains", userID)
+		single = firestoreClient.CollectionGroup("chores").Where("chore_assignee", "==", userID)
+	}
+
+	// Chores created before assignees existed only have chore_assignee,
+	// so both queries are run and merged
+	docs, err := shared.Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list chores: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	legacy, err := single.Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list chores: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	docs = append(docs, legacy...)
+
+	// The collection group queries don't know about the groups themselves
+	var visible map[string]bool
+	if groupID == "" {
+		var groupRefs []*firestore.DocumentRef
+		known := make(map[string]bool)
+		for _, doc := range docs {
+			if ref := doc.Ref.Parent.Parent; !known[ref.ID] {
+				known[ref.ID] = true
+				groupRefs = append(groupRefs, ref)
+			}
+		}
+		if visible, err = memberGroups(ctx, groupRefs, userID); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list chores: %v", err), http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	seen := make(map[string]bool, len(docs))
+	chores := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		data := doc.Data()
+		if seen[doc.Ref.Path] || isClosed(data) {
+			continue
+		}
+		if visible != nil && !visible[doc.Ref.Parent.Parent.ID] {
+			continue
+		}
+		seen[doc.Ref.Path] = true
+		assignees := choreAssignees(data)
+		chore := map[string]interface{}{
+			"group_id":          doc.Ref.Parent.Parent.ID,
+			"chore_id":          doc.Ref.ID,
+			"chore_name":        data["chore_name"],
+			"chore_details":     data["chore_details"],
+			"chore_due_date":    data["chore_due_date"],
+			"chore_frequency":   data["chore_frequency"],
+			"chore_status":      data["chore_status"],
+			"assignees":         assignees,
+			"completion_policy": completionPolicy(data),
+		}
+		if completionPolicy(data) == policyAll {
+			done := stringList(data["completed_by_members"])
+			chore["completed_by_members"] = done
+			chore["my_part_done"] = containsString(done, userID)
+		}
+		chores = append(chores, chore)
+	}
+	sort.Slice(chores, func(i, j int) bool {
+		a, _ := chores[i]["chore_due_date"].(string)
+		b, _ := chores[j]["chore_due_date"].(string)
+		return a < b
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"user_id": userID,
+		"chores":  chores,
+	})
+	return nil
+}
@@ -0,0 +1,490 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Proof of completion: photos and notes attached to a chore.
+
+	/groups/{groupId}/chores/{choreId}/attachments/{attachmentId}
+		kind ("photo" | "note"), status ("pending" | "ready"),
+		object, content_type, size_bytes (photos), note,
+		uploaded_by, created_at, ready_at
+
+	A photo starts out pending with a signed upload URL. Once the app has
+	uploaded it, confirm checks the file really is there and within the
+	limits before marking it ready. Ready attachments are listed on the
+	chore's attachments field, and completing a chore can point at them
+	with attachment_ids so they end up on its history event too.
+*/
+
+const (
+	maxAttachmentBytes  = 10 << 20
+	maxChoreAttachments = 20
+	uploadURLTTL        = 15 * time.Minute
+	downloadURLTTL      = 15 * time.Minute
+)
+
+// allowedPhotoTypes maps the content types photos can have to the file
+// extension they are stored with.
+var allowedPhotoTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/heic": ".heic",
+}
+
+var (
+	errAttachmentNotFound = errors.New("attachment not found")
+	errTooManyAttachments = errors.New("too many attachments on this chore")
+	errAttachmentNotReady = errors.New("attachment has not been uploaded yet")
+	errBadUpload          = errors.New("uploaded file does not match the attachment")
+)
+
+func writeAttachmentError(w http.ResponseWriter, err error) error {
+	code := statusForError(err)
+	switch {
+	case errors.Is(err, errAttachmentNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errTooManyAttachments):
+		code = http.StatusConflict
+	case errors.Is(err, errAttachmentNotReady), errors.Is(err, errBadUpload):
+		code = http.StatusBadRequest
+	}
+	http.Error(w, fmt.Sprintf("Failed to update attachment: %v", err), code)
+	return err
+}
+
+// attachmentSummary is what the chore's attachments field keeps about a
+// ready attachment.
+func attachmentSummary(id string, data map[string]interface{}) map[string]string {
+	summary := map[string]string{"id": id}
+	for _, field := range []string{"kind", "content_type", "note", "uploaded_by"} {
+		if v, _ := data[field].(string); v != "" {
+			summary[field] = v
+		}
+	}
+	return summary
+}
+
+// addToChore appends a ready attachment to the chore's attachments in tx.
+// It reads the chore, so call it before any writes.
+func addToChore(tx *firestore.Transaction, choreRef *firestore.DocumentRef, summary map[string]string) (func() error, error) {
+	snap, err := tx.Get(choreRef)
+	if status.Code(err) == codes.NotFound {
+		return nil, errChoreNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	existing, _ := snap.Data()["attachments"].([]interface{})
+	if len(existing) >= maxChoreAttachments {
+		return nil, errTooManyAttachments
+	}
+	return func() error {
+		return tx.Update(choreRef, []firestore.Update{
+			{Path: "attachments", Value: firestore.ArrayUnion(summary)},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		})
+	}, nil
+}
+
+// checkPhotoRoom makes sure a chore can take another photo in tx. Pending
+// photos count against maxChoreAttachments along with the ready ones, so
+// a chore can't pile up signed uploads that are never confirmed. It only
+// reads, so call it before any writes.
+func checkPhotoRoom(tx *firestore.Transaction, choreRef *firestore.DocumentRef) error {
+	snap, err := tx.Get(choreRef)
+	if status.Code(err) == codes.NotFound {
+		return errChoreNotFound
+	}
+	if err != nil {
+		return err
+	}
+	ready, _ := snap.Data()["attachments"].([]interface{})
+	pending, err := tx.Documents(choreRef.Collection("attachments").
+		Where("status", "==", "pending").
+		Limit(maxChoreAttachments)).GetAll()
+	if err != nil {
+		return err
+	}
+	if len(ready)+len(pending) >= maxChoreAttachments {
+		return errTooManyAttachments
+	}
+	return nil
+}
+
+// createAttachmentHandler adds a note, or starts a photo upload and returns
+// where to PUT the file.
+//
+//	POST /groups/{groupId}/chores/{choreId}/attachments {"user_id", "kind", "content_type", "size_bytes", "note"}
+func createAttachmentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID      string `json:"user_id"`
+		Kind        string `json:"kind"` // "photo" (default) or "note"
+		ContentType string `json:"content_type"`
+		SizeBytes   int64  `json:"size_bytes"`
+		Note        string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	if req.Kind == "" {
+		req.Kind = "photo"
+	}
+	if len(req.Note) > maxNotesLength {
+		http.Error(w, fmt.Sprintf("note can be at most %d characters", maxNotesLength), http.StatusBadRequest)
+		return fmt.Errorf("note too long")
+	}
+	var ext string
+	switch req.Kind {
+	case "note":
+		if req.Note == "" {
+			http.Error(w, "note is required", http.StatusBadRequest)
+			return fmt.Errorf("missing note")
+		}
+	case "photo":
+		var ok bool
+		if ext, ok = allowedPhotoTypes[req.ContentType]; !ok {
+			http.Error(w, fmt.Sprintf("Unsupported content_type %q; use image/jpeg, image/png, image/webp or image/heic", req.ContentType), http.StatusUnsupportedMediaType)
+			return fmt.Errorf("unsupported content type")
+		}
+		if req.SizeBytes <= 0 || req.SizeBytes > maxAttachmentBytes {
+			http.Error(w, fmt.Sprintf("size_bytes must be between 1 and %d", maxAttachmentBytes), http.StatusRequestEntityTooLarge)
+			return fmt.Errorf("attachment too large")
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Invalid kind %q; use photo or note", req.Kind), http.StatusBadRequest)
+		return fmt.Errorf("invalid kind")
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add attachment: %v", err), statusForError(err))
+		return err
+	}
+	choreRef := groupSnap.Ref.Collection("chores").Doc(choreID)
+	ref := choreRef.Collection("attachments").NewDoc()
+
+	data := map[string]interface{}{
+		"kind":        req.Kind,
+		"uploaded_by": req.UserID,
+		"note":        req.Note,
+		"created_at":  firestore.ServerTimestamp,
+	}
+	var target uploadTarget
+	if req.Kind == "photo" {
+		object := fmt.Sprintf("groups/%s/chores/%s/%s%s", groupID, choreID, ref.ID, ext)
+		data["status"] = "pending"
+		data["object"] = object
+		data["content_type"] = req.ContentType
+		data["size_bytes"] = req.SizeBytes
+
+		s, err := attachmentStorage(ctx)
+		if err == nil {
+			target, err = s.UploadURL(ctx, object, req.ContentType, maxAttachmentBytes, time.Now().Add(uploadURLTTL))
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to add attachment: %v", err), http.StatusInternalServerError)
+			return err
+		}
+	} else {
+		data["status"] = "ready"
+		data["ready_at"] = firestore.ServerTimestamp
+	}
+
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		var addNote func() error
+		if req.Kind == "note" {
+			var err error
+			if addNote, err = addToChore(tx, choreRef, attachmentSummary(ref.ID, data)); err != nil {
+				return err
+			}
+		} else if err := checkPhotoRoom(tx, choreRef); err != nil {
+			return err
+		}
+		if err := tx.Create(ref, data); err != nil {
+			return err
+		}
+		if addNote != nil {
+			return addNote()
+		}
+		return nil
+	})
+	if err != nil {
+		return writeAttachmentError(w, err)
+	}
+
+	resp := map[string]interface{}{
+		"attachment_id": ref.ID,
+		"kind":          req.Kind,
+		"status":        data["status"],
+	}
+	if req.Kind == "photo" {
+		resp["upload"] = target
+		resp["upload_expires_at"] = time.Now().Add(uploadURLTTL).UTC().Format(time.RFC3339)
+		resp["max_size_bytes"] = maxAttachmentBytes
+	}
+	writeJSON(w, resp)
+	return nil
+}
+
+// confirmAttachmentHandler checks an uploaded photo and makes it ready.
+//
+//	POST /groups/{groupId}/chores/{choreId}/attachments/{attachmentId}/confirm {"user_id"}
+func confirmAttachmentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, groupSnap, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	choreID, attachmentID := r.PathValue("choreId"), r.PathValue("attachmentId")
+	choreRef := groupSnap.Ref.Collection("chores").Doc(choreID)
+	ref := choreRef.Collection("attachments").Doc(attachmentID)
+
+	snap, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return writeAttachmentError(w, errAttachmentNotFound)
+	}
+	if err != nil {
+		return writeAttachmentError(w, err)
+	}
+	data := snap.Data()
+	if by, _ := data["uploaded_by"].(string); by != req.UserID {
+		return writeAttachmentError(w, errAttachmentNotFound)
+	}
+	if s, _ := data["status"].(string); s == "ready" {
+		writeJSON(w, map[string]string{"attachment_id": attachmentID, "status": "ready"})
+		return nil
+	}
+
+	object, _ := data["object"].(string)
+	contentType, _ := data["content_type"].(string)
+	s, err := attachmentStorage(ctx)
+	if err != nil {
+		return writeAttachmentError(w, err)
+	}
+	info, err := s.Stat(ctx, object)
+	if errors.Is(err, errObjectNotFound) {
+		return writeAttachmentError(w, errAttachmentNotReady)
+	}
+	if err != nil {
+		return writeAttachmentError(w, err)
+	}
+	if info.Size <= 0 || info.Size > maxAttachmentBytes || (info.ContentType != "" && info.ContentType != contentType) {
+		// Don't keep files that got around the limits
+		if err := s.Delete(ctx, object); err != nil {
+			return writeAttachmentError(w, err)
+		}
+		return writeAttachmentError(w, fmt.Errorf("%w: %d bytes of %s", errBadUpload, info.Size, info.ContentType))
+	}
+
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		add, err := addToChore(tx, choreRef, attachmentSummary(attachmentID, data))
+		if err != nil {
+			return err
+		}
+		if err := tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: "ready"},
+			{Path: "size_bytes", Value: info.Size},
+			{Path: "ready_at", Value: firestore.ServerTimestamp},
+		}); err != nil {
+			return err
+		}
+		return add()
+	})
+	if err != nil {
+		return writeAttachmentError(w, err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"attachment_id": attachmentID,
+		"status":        "ready",
+		"size_bytes":    info.Size,
+	})
+	return nil
+}
+
+// checkAttachments makes sure every id is a ready attachment on the chore,
+// for completing with proof.
+func checkAttachments(ctx context.Context, choreRef *firestore.DocumentRef, ids []string) error {
+	if len(ids) > maxChoreAttachments {
+		return errTooManyAttachments
+	}
+	refs := make([]*firestore.DocumentRef, 0, len(ids))
+	for _, id := range ids {
+		if id == "" {
+			return errAttachmentNotFound
+		}
+		refs = append(refs, choreRef.Collection("attachments").Doc(id))
+	}
+	snaps, err := firestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return err
+	}
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			return fmt.Errorf("%w: %s", errAttachmentNotFound, snap.Ref.ID)
+		}
+		if s, _ := snap.Data()["status"].(string); s != "ready" {
+			return fmt.Errorf("%w: %s", errAttachmentNotReady, snap.Ref.ID)
+		}
+	}
+	return nil
+}
+
+// withAttachments adds attachment_ids to a history event when there are any.
+func withAttachments(event map[string]interface{}, ids []string) map[string]interface{} {
+	if len(ids) > 0 {
+		event["attachment_ids"] = ids
+	}
+	return event
+}
+
+// listAttachmentsHandler lists a chore's ready attachments, with short lived
+// download URLs for photos.
+//
+//	GET /groups/{groupId}/chores/{choreId}/attachments?user_id=...
+func listAttachmentsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list attachments: %v", err), statusForError(err))
+		return err
+	}
+	docs, err := groupSnap.Ref.Collection("chores").Doc(choreID).Collection("attachments").
+		Where("status", "==", "ready").Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list attachments: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	var s attachmentStore
+	expires := time.Now().Add(downloadURLTTL)
+	attachments := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		data := doc.Data()
+		a := map[string]interface{}{}
+		for k, v := range attachmentSummary(doc.Ref.ID, data) {
+			a[k] = v
+		}
+		if t, ok := data["created_at"].(time.Time); ok {
+			a["created_at"] = t.UTC().Format(time.RFC3339)
+		}
+		if object, _ := data["object"].(string); object != "" {
+			if s == nil {
+				if s, err = attachmentStorage(ctx); err != nil {
+					http.Error(w, fmt.Sprintf("Failed to list attachments: %v", err), http.StatusInternalServerError)
+					return err
+				}
+			}
+			u, err := s.DownloadURL(ctx, object, expires)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to list attachments: %v", err), http.StatusInternalServerError)
+				return err
+			}
+			a["size_bytes"] = data["size_bytes"]
+			a["download_url"] = u
+		}
+		attachments = append(attachments, a)
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		a, _ := attachments[i]["created_at"].(string)
+		b, _ := attachments[j]["created_at"].(string)
+		return a < b
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"chore_id":    choreID,
+		"attachments": attachments,
+		"expires_at":  expires.UTC().Format(time.RFC3339),
+	})
+	return nil
+}
+
+// deleteAttachmentHandler removes an attachment and its file. Whoever added
+// it and the group owner can.
+//
+//	DELETE /groups/{groupId}/chores/{choreId}/attachments/{attachmentId}?user_id=...
+func deleteAttachmentHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, choreID, attachmentID := r.PathValue("groupId"), r.PathValue("choreId"), r.PathValue("attachmentId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete attachment: %v", err), statusForError(err))
+		return err
+	}
+	choreRef := groupSnap.Ref.Collection("chores").Doc(choreID)
+	ref := choreRef.Collection("attachments").Doc(attachmentID)
+
+	var object string
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errAttachmentNotFound
+		}
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		owner, _ := groupSnap.Data()["created_by"].(string)
+		if by, _ := data["uploaded_by"].(string); by != userID && owner != userID {
+			return errAttachmentNotFound
+		}
+		object, _ = data["object"].(string)
+		if err := tx.Delete(ref); err != nil {
+			return err
+		}
+		return tx.Update(choreRef, []firestore.Update{
+			{Path: "attachments", Value: firestore.ArrayRemove(attachmentSummary(attachmentID, data))},
+		})
+	})
+	if err != nil {
+		return writeAttachmentError(w, err)
+	}
+
+	if object != "" {
+		s, err := attachmentStorage(ctx)
+		if err == nil {
+			err = s.Delete(ctx, object)
+		}
+		if err != nil {
+			// The doc is gone either way; a stray file is only wasted space
+			log.Printf("Failed to delete attachment file %s: %v", object, err)
+		}
+	}
+
+	writeJSON(w, map[string]string{
+		"message":       fmt.Sprintf("Attachment %s deleted", attachmentID),
+		"attachment_id": attachmentID,
+	})
+	return nil
+}
@@ -0,0 +1,404 @@
+package chores
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Calendar feeds: a secret .ics URL a member can subscribe to from
+	their calendar app.
+
+	/calendar_feeds/{token}
+		user_id, group_id, scope ("mine" | "all"), created_at, revoked_at
+
+	The token is the only credential, so anyone with the URL can read the
+	feed; revoking it (or leaving the group) stops it working. Each open
+	chore becomes an all day VEVENT on its due date (or a VTODO with
+	?kind=todo), and recurring chores get an RRULE from chore_frequency.
+*/
+
+const maxCalendarFeeds = 10
+
+var errFeedNotFound = errors.New("calendar feed not found")
+
+func newFeedToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate feed token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// feedURL is where the feed for token can be fetched. CALENDAR_FEED_BASE
+// overrides the host the request came in on.
+func feedURL(r *http.Request, token string) string {
+	base := os.Getenv("CALENDAR_FEED_BASE")
+	if base == "" {
+		base = "https://" + r.Host
+	}
+	return strings.TrimSuffix(base, "/") + "/calendar/" + token + ".ics"
+}
+
+// createFeedHandler makes a new feed URL for the caller.
+//
+//	POST /groups/{groupId}/calendar {"user_id", "scope": "mine" | "all"}
+func createFeedHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		Scope  string `json:"scope"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	if req.Scope == "" {
+		req.Scope = "mine"
+	}
+	if req.Scope != "mine" && req.Scope != "all" {
+		http.Error(w, fmt.Sprintf("Invalid scope %q; use mine or all", req.Scope), http.StatusBadRequest)
+		return fmt.Errorf("invalid scope")
+	}
+	groupID := r.PathValue("groupId")
+
+	if _, err := requireGroupMember(ctx, groupID, req.UserID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create calendar feed: %v", err), statusForError(err))
+		return err
+	}
+
+	feeds := firestoreClient.Collection("calendar_feeds")
+	existing, err := feeds.Where("user_id", "==", req.UserID).Where("group_id", "==", groupID).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create calendar feed: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	active := 0
+	for _, doc := range existing {
+		if _, revoked := doc.Data()["revoked_at"]; !revoked {
+			active++
+		}
+	}
+	if active >= maxCalendarFeeds {
+		http.Error(w, fmt.Sprintf("You can have at most %d calendar feeds per group; revoke one first", maxCalendarFeeds), http.StatusConflict)
+		return fmt.Errorf("too many calendar feeds")
+	}
+
+	token, err := newFeedToken()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create calendar feed: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	_, err = feeds.Doc(token).Create(ctx, map[string]interface{}{
+		"user_id":    req.UserID,
+		"group_id":   groupID,
+		"scope":      req.Scope,
+		"created_at": firestore.ServerTimestamp,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create calendar feed: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]string{
+		"message": "Calendar feed created",
+		"token":   token,
+		"scope":   req.Scope,
+		"url":     feedURL(r, token),
+	})
+	return nil
+}
+
+// listFeedsHandler lists the caller's active feeds for the group.
+//
+//	GET /groups/{groupId}/calendar?user_id=...
+func listFeedsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	docs, err := firestoreClient.Collection("calendar_feeds").
+		Where("user_id", "==", userID).
+		Where("group_id", "==", groupID).
+		Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list calendar feeds: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	feeds := []map[string]string{}
+	for _, doc := range docs {
+		data := doc.Data()
+		if _, revoked := data["revoked_at"]; revoked {
+			continue
+		}
+		feed := map[string]string{
+			"token": doc.Ref.ID,
+			"url":   feedURL(r, doc.Ref.ID),
+		}
+		feed["scope"], _ = data["scope"].(string)
+		if t, ok := data["created_at"].(time.Time); ok {
+			feed["created_at"] = t.UTC().Format(time.RFC3339)
+		}
+		feeds = append(feeds, feed)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"feeds":    feeds,
+	})
+	return nil
+}
+
+// revokeFeedHandler turns a feed off for good.
+//
+//	DELETE /groups/{groupId}/calendar/{token}?user_id=...
+func revokeFeedHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, token := r.PathValue("groupId"), r.PathValue("token")
+
+	ref := firestoreClient.Collection("calendar_feeds").Doc(token)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errFeedNotFound
+		}
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		owner, _ := data["user_id"].(string)
+		group, _ := data["group_id"].(string)
+		if owner != userID || group != groupID {
+			return errFeedNotFound
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "revoked_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	if errors.Is(err, errFeedNotFound) {
+		http.Error(w, fmt.Sprintf("Failed to revoke calendar feed: %v", err), http.StatusNotFound)
+		return err
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke calendar feed: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]string{
+		"message": "Calendar feed revoked",
+		"token":   token,
+	})
+	return nil
+}
+
+// calendarFeedHandler serves the .ics for a feed token. Unknown, revoked
+// and orphaned tokens all answer 404 so they can't be told apart.
+//
+//	GET /calendar/{token}.ics?kind=event|todo
+func calendarFeedHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	token := strings.TrimSuffix(r.PathValue("token"), ".ics")
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && kind != "event" && kind != "todo" {
+		http.Error(w, "kind must be event or todo", http.StatusBadRequest)
+		return fmt.Errorf("invalid kind %q", kind)
+	}
+
+	if token == "" {
+		http.NotFound(w, r)
+		return errFeedNotFound
+	}
+
+	snap, err := firestoreClient.Collection("calendar_feeds").Doc(token).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		http.NotFound(w, r)
+		return errFeedNotFound
+	}
+	if err != nil {
+		http.Error(w, "Failed to read calendar feed", http.StatusInternalServerError)
+		return err
+	}
+	feed := snap.Data()
+	if _, revoked := feed["revoked_at"]; revoked {
+		http.NotFound(w, r)
+		return errFeedNotFound
+	}
+	userID, _ := feed["user_id"].(string)
+	groupID, _ := feed["group_id"].(string)
+	scope, _ := feed["scope"].(string)
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		if errors.Is(err, errGroupNotFound) || errors.Is(err, errGroupDeleted) || errors.Is(err, errNotGroupMember) {
+			http.NotFound(w, r)
+			return err
+		}
+		http.Error(w, "Failed to read calendar feed", http.StatusInternalServerError)
+		return err
+	}
+	docs, err := groupSnap.Ref.Collection("chores").Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, "Failed to read chores", http.StatusInternalServerError)
+		return err
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Ref.ID < docs[j].Ref.ID })
+
+	groupName, _ := groupSnap.Data()["group_name"].(string)
+	if groupName == "" {
+		groupName = "Chores"
+	}
+	cal := newICalendar(groupName, groupLocation(groupSnap).String())
+	now := time.Now().UTC()
+	for _, doc := range docs {
+		data := doc.Data()
+		if isClosed(data) {
+			continue
+		}
+		if scope == "mine" && !containsString(choreAssignees(data), userID) {
+			continue
+		}
+		cal.addChore(doc.Ref.ID, groupID, data, kind == "todo", now)
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	_, err = w.Write([]byte(cal.String()))
+	return err
+}
+
+// iCalendar builds an RFC 5545 calendar one content line at a time.
+type iCalendar struct {
+	b strings.Builder
+}
+
+func newICalendar(name string, timezone string) *iCalendar {
+	c := &iCalendar{}
+	c.line("BEGIN:VCALENDAR")
+	c.line("VERSION:2.0")
+	c.line("PRODID:-//Roommates//Chores//EN")
+	c.line("CALSCALE:GREGORIAN")
+	c.line("METHOD:PUBLISH")
+	c.line("X-WR-CALNAME:" + icalText(name))
+	c.line("X-WR-TIMEZONE:" + timezone)
+	c.line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	return c
+}
+
+// addChore adds one chore as an all day event on its due date, or a to-do
+// due that day.
+func (c *iCalendar) addChore(choreID string, groupID string, chore map[string]interface{}, todo bool, now time.Time) {
+	dueStr, _ := chore["chore_due_date"].(string)
+	due, err := time.Parse(dateLayout, dueStr)
+	if err != nil {
+		return
+	}
+	name, _ := chore["chore_name"].(string)
+	details, _ := chore["chore_details"].(string)
+	component := "VEVENT"
+	if todo {
+		component = "VTODO"
+	}
+
+	c.line("BEGIN:" + component)
+	c.line(fmt.Sprintf("UID:%s-%s@roommates", groupID, choreID))
+	c.line("DTSTAMP:" + now.Format("20060102T150405Z"))
+	c.line("SUMMARY:" + icalText(name))
+	if details != "" {
+		c.line("DESCRIPTION:" + icalText(details))
+	}
+	if todo {
+		c.line("DTSTART;VALUE=DATE:" + due.Format("20060102"))
+		c.line("DUE;VALUE=DATE:" + due.AddDate(0, 0, 1).Format("20060102"))
+		c.line("STATUS:NEEDS-ACTION")
+	} else {
+		c.line("DTSTART;VALUE=DATE:" + due.Format("20060102"))
+		c.line("DTEND;VALUE=DATE:" + due.AddDate(0, 0, 1).Format("20060102"))
+		c.line("TRANSP:TRANSPARENT")
+	}
+	frequency, _ := chore["chore_frequency"].(string)
+	if rule := rrule(frequency, scheduleDay(chore)); rule != "" {
+		c.line("RRULE:" + rule)
+	}
+	c.line("END:" + component)
+}
+
+func (c *iCalendar) String() string {
+	return c.b.String() + "END:VCALENDAR\r\n"
+}
+
+// line writes a content line, folded at 75 octets as RFC 5545 requires.
+func (c *iCalendar) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8Start(s[cut]) {
+			cut-- // don't split a multi-byte character
+		}
+		c.b.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74 // continuation lines start with a space
+	}
+	c.b.WriteString(s + "\r\n")
+}
+
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// icalText escapes a TEXT value.
+func icalText(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(s)
+}
+
+// rrule maps chore_frequency to a recurrence rule; one-off chores get "".
+// Monthly chores scheduled past the 28th fall back to the last day of
+// shorter months, as nextDueDate does.
+func rrule(frequency string, day int) string {
+	switch frequency {
+	case "daily":
+		return "FREQ=DAILY"
+	case "weekly":
+		return "FREQ=WEEKLY"
+	case "monthly":
+		if day > 28 {
+			days := make([]string, 0, 4)
+			for d := 28; d <= day; d++ {
+				days = append(days, strconv.Itoa(d))
+			}
+			return "FREQ=MONTHLY;BYMONTHDAY=" + strings.Join(days, ",") + ";BYSETPOS=-1"
+		}
+		return "FREQ=MONTHLY"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,273 @@
+package chores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	Up for grabs: chores created without a chore_assignee can be claimed by
+	any member. Claims run in a transaction so two roommates can't both
+	get the same chore.
+
+	A chore can also have a claim_deadline; if nobody has claimed it by
+	then, the auto-assign job hands it to whoever has the lightest workload
+	(see leastLoadedMember).
+*/
+
+const (
+	autoAssignBatchSize  = 100
+	autoAssignTimeBudget = 45 * time.Second
+)
+
+var (
+	errChoreTaken      = errors.New("chore is already assigned")
+	errNotChoreClaimer = errors.New("only whoever claimed the chore can unclaim it")
+)
+
+func writeClaimError(w http.ResponseWriter, err error) error {
+	switch {
+	case errors.Is(err, errChoreTaken):
+		http.Error(w, fmt.Sprintf("Failed to claim chore: %v", err), http.StatusConflict)
+		return err
+	case errors.Is(err, errNotChoreClaimer):
+		http.Error(w, fmt.Sprintf("Failed to unclaim chore: %v", err), http.StatusForbidden)
+		return err
+	}
+	return writeChangeError(w, err)
+}
+
+// claimChoreHandler assigns an unassigned chore to the caller.
+func claimChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, _, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	err = changeChore(ctx, groupID, choreID, req.UserID, "claimed", req.Notes, func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+		if isClosed(chore) {
+			return nil, nil, errChoreClosed
+		}
+		if len(choreAssignees(chore)) > 0 {
+			return nil, nil, errChoreTaken
+		}
+		return append(assigneeUpdates(chore, []string{req.UserID}),
+			firestore.Update{Path: "claimed_by", Value: req.UserID},
+			firestore.Update{Path: "claimed_at", Value: firestore.ServerTimestamp},
+			firestore.Update{Path: "claim_deadline", Value: firestore.Delete},
+		), nil, nil
+	})
+	if err != nil {
+		return writeClaimError(w, err)
+	}
+
+	writeJSON(w, map[string]string{
+		"message":  fmt.Sprintf("Chore %s claimed", choreID),
+		"chore_id": choreID,
+		"assignee": req.UserID,
+	})
+	return nil
+}
+
+// unclaimChoreHandler puts a chore the caller claimed back up for grabs.
+func unclaimChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, _, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	err = changeChore(ctx, groupID, choreID, req.UserID, "unclaimed", req.Notes, func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+		if claimedBy, _ := chore["claimed_by"].(string); claimedBy != req.UserID {
+			return nil, nil, errNotChoreClaimer
+		}
+		return append(assigneeUpdates(chore, []string{}),
+			firestore.Update{Path: "claimed_by", Value: firestore.Delete},
+			firestore.Update{Path: "claimed_at", Value: firestore.Delete},
+		), nil, nil
+	})
+	if err != nil {
+		return writeClaimError(w, err)
+	}
+
+	writeJSON(w, map[string]string{
+		"message":  fmt.Sprintf("Chore %s is up for grabs", choreID),
+		"chore_id": choreID,
+	})
+	return nil
+}
+
+// openChoresHandler lists the group's unassigned chores, soonest due first.
+//
+//	GET /groups/{groupId}/chores/open?user_id=...
+func openChoresHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list open chores: %v", err), statusForError(err))
+		return err
+	}
+
+	docs, err := groupSnap.Ref.Collection("chores").Where("chore_assignee", "==", "").Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list open chores: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	chores := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		data := doc.Data()
+		if isClosed(data) {
+			continue
+		}
+		chore := map[string]interface{}{
+			"chore_id":          doc.Ref.ID,
+			"chore_name":        data["chore_name"],
+			"chore_details":     data["chore_details"],
+			"chore_due_date":    data["chore_due_date"],
+			"chore_frequency":   data["chore_frequency"],
+			"estimated_minutes": data["estimated_minutes"],
+		}
+		if t, ok := data["claim_deadline"].(time.Time); ok {
+			chore["claim_deadline"] = t.UTC().Format(time.RFC3339)
+		}
+		chores = append(chores, chore)
+	}
+	sort.Slice(chores, func(i, j int) bool {
+		a, _ := chores[i]["chore_due_date"].(string)
+		b, _ := chores[j]["chore_due_date"].(string)
+		return a < b
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"chores":   chores,
+	})
+	return nil
+}
+
+// autoAssignChoresHandler assigns every open chore whose claim_deadline has
+// passed. It pages past chores it can't assign, so they don't hold up the
+// rest, until there are none left or the time budget runs out (done is
+// false then). Closed chores it finds have their deadline cleared. Meant
+// to be hit by Cloud Scheduler.
+func autoAssignChoresHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	deadline := time.Now().Add(autoAssignTimeBudget)
+	query := firestoreClient.CollectionGroup("chores").
+		Where("chore_assignee", "==", "").
+		Where("claim_deadline", "<=", time.Now()).
+		OrderBy("claim_deadline", firestore.Asc).
+		Limit(autoAssignBatchSize)
+
+	assigned, failed := 0, 0
+	done := false
+	var last *firestore.DocumentSnapshot
+pages:
+	for time.Now().Before(deadline) {
+		page := query
+		if last != nil {
+			page = query.StartAfter(last)
+		}
+		docs, err := page.Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to find unclaimed chores: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		for _, doc := range docs {
+			if time.Now().After(deadline) {
+				break pages
+			}
+			last = doc
+			if isClosed(doc.Data()) {
+				if _, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "claim_deadline", Value: firestore.Delete}}); err != nil {
+					log.Printf("Failed to clear claim_deadline of closed chore %s: %v", doc.Ref.Path, err)
+				}
+				continue
+			}
+			if err := autoAssignChore(ctx, doc.Ref); err != nil {
+				log.Printf("Failed to auto-assign chore %s: %v", doc.Ref.Path, err)
+				failed++
+				continue
+			}
+			assigned++
+		}
+		if len(docs) < autoAssignBatchSize {
+			done = true
+			break
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"assigned": assigned,
+		"failed":   failed,
+		"done":     done,
+	})
+	return nil
+}
+
+// autoAssignChore gives one unclaimed chore to the least loaded member.
+func autoAssignChore(ctx context.Context, choreRef *firestore.DocumentRef) error {
+	groupRef := choreRef.Parent.Parent
+	groupSnap, err := groupRef.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read group %s: %w", groupRef.ID, err)
+	}
+	if _, deleted := groupSnap.Data()["deleted_at"]; deleted {
+		return errGroupDeleted
+	}
+	members, err := groupMembers(ctx, groupSnap)
+	if err != nil {
+		return err
+	}
+	candidates := make([]string, 0, len(members))
+	for uid := range members {
+		candidates = append(candidates, uid)
+	}
+	// Skip anyone away when it's due
+	due := groupToday(groupSnap)
+	if choreSnap, err := choreRef.Get(ctx); err == nil {
+		if d, _ := choreSnap.Data()["chore_due_date"].(string); d > due {
+			due = d
+		}
+	}
+	if candidates, err = availableOn(ctx, groupSnap, candidates, due); err != nil {
+		return err
+	}
+	assignee, err := leastLoadedMember(ctx, groupSnap, candidates)
+	if err != nil {
+		return err
+	}
+	if assignee == "" {
+		return fmt.Errorf("group %s has no members", groupRef.ID)
+	}
+
+	err = changeChore(ctx, groupRef.ID, choreRef.ID, "system", "auto_assigned", "", func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+		if len(choreAssignees(chore)) > 0 {
+			return nil, nil, errChoreTaken
+		}
+		return append(assigneeUpdates(chore, []string{assignee}),
+			firestore.Update{Path: "claim_deadline", Value: firestore.Delete},
+		), map[string]interface{}{
+			"to_assignee": assignee,
+		}, nil
+	})
+	if errors.Is(err, errChoreTaken) {
+		// Claimed since the query ran; nothing to do
+		return nil
+	}
+	return err
+}
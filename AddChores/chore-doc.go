@@ -1,32 +1,43 @@
 package chores
 
+import "time"
+
 type Chore struct {
     Title            string                 `firestore:"title"`
     // Notes            string                 `firestore:"notes,omitempty"`
     // Status           string                 `firestore:"status"` // open, in_progress, done, skipped
-    // Priority         int                    `firestore:"priority"`
+    Priority         int                    `firestore:"priority"` // 0 (none) to 3 (high)
+    Points           int                    `firestore:"points"`   // 0: one per 5 estimated minutes
     // CreatedBy        string                 `firestore:"created_by"`
     // CreatedAt        interface{}            `firestore:"created_at"`  // set: firestore.ServerTimestamp
     // UpdatedAt        interface{}            `firestore:"updated_at"`  // set: firestore.ServerTimestamp
-    // Assignees        []string               `firestore:"assignees"`
-    // ClaimedBy        *string                `firestore:"claimed_by,omitempty"`
-    // EstimatedMinutes int                    `firestore:"estimated_minutes"`
+    Assignees        []string               `firestore:"assignees"`
+    CompletionPolicy string                 `firestore:"completion_policy"` // any, all
+    CompletedByMembers []string             `firestore:"completed_by_members"`
+    ClaimedBy        *string                `firestore:"claimed_by,omitempty"`
+    EstimatedMinutes int                    `firestore:"estimated_minutes"`
 
     // Schedule         map[string]interface{} `firestore:"schedule"`          // store rule/one_time fields
     // NextOccurrenceAt time.Time              `firestore:"next_occurrence_at"`
-    // LastCompletedAt  *time.Time             `firestore:"last_completed_at,omitempty"`
+    LastCompletedAt  *time.Time             `firestore:"last_completed_at,omitempty"`
 
     // Rotation         map[string]interface{} `firestore:"rotation"`          // mode, queue
 
     // Reminders        map[string]interface{} `firestore:"reminders"`         // enabled, offsets, channels
-    // Snooze           map[string]interface{} `firestore:"snooze"`            // minutes
+    Snooze           map[string]interface{} `firestore:"snooze,omitempty"`  // until, minutes, by
+    ScheduleAnchor   string                 `firestore:"schedule_anchor,omitempty"` // due date before snoozes
+    ScheduleDay      int                    `firestore:"schedule_day,omitempty"` // monthly chores: day of the month first scheduled for
 
     // Completed        bool                   `firestore:"completed"`
     // CompletedAt      *time.Time             `firestore:"completed_at,omitempty"`
-    // CompletedBy      *string                `firestore:"completed_by,omitempty"`
-    // StreakCount      int                    `firestore:"streak_count"`
-    // MissedCount      int                    `firestore:"missed_count"`
+    CompletedBy      *string                `firestore:"completed_by,omitempty"`
+    StreakCount      int                    `firestore:"streak_count"`
+    MissedCount      int                    `firestore:"missed_count"`
+
+    RequiresVerification *bool              `firestore:"requires_verification,omitempty"` // unset: group's settings.require_verification
+    PendingCompletion map[string]interface{} `firestore:"pending_completion,omitempty"`
+    VerifiedBy       *string                `firestore:"verified_by,omitempty"`
 
-    // Tags             []string               `firestore:"tags,omitempty"`
-    // Attachments      []map[string]string    `firestore:"attachments,omitempty"`
+    Tags             []string               `firestore:"tags,omitempty"`
+    Attachments      []map[string]string    `firestore:"attachments,omitempty"`
 }
@@ -0,0 +1,479 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Priority, tags and editing chores.
+
+	priority is 0 (none) to 3 (high). tags are lower case words from the
+	group's tag vocabulary,
+
+	/groups/{groupId}/tags/{tag}
+		name, color, created_by, created_at
+
+	so a group gets a consistent set to filter on instead of "kitchen",
+	"Kitchen" and "kitchn". GetChoreHandler filters and sorts on both,
+	along with estimated_minutes.
+*/
+
+const (
+	maxPriority     = 3
+	maxChoreTags    = 10
+	maxGroupTags    = 50
+	maxTagLength    = 30
+	maxChoreNameLen = 200
+)
+
+var (
+	tagPattern   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+	errBadTags     = errors.New("invalid tags")
+	errTagNotFound = errors.New("tag not found")
+	errCantDelete  = errors.New("only whoever created the chore or the group owner can delete it")
+)
+
+// normalizeTag lower cases and trims a tag, and checks its shape.
+func normalizeTag(tag string) (string, error) {
+	t := strings.ToLower(strings.TrimSpace(tag))
+	if len(t) == 0 || len(t) > maxTagLength || !tagPattern.MatchString(t) {
+		return "", fmt.Errorf("invalid tag %q; use up to %d lower case letters, digits, - and _", tag, maxTagLength)
+	}
+	return t, nil
+}
+
+// checkTags normalizes tags, drops duplicates and makes sure every one is
+// in the group's vocabulary.
+func checkTags(ctx context.Context, groupRef *firestore.DocumentRef, tags []string) ([]string, error) {
+	list := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		t, err := normalizeTag(tag)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errBadTags, err)
+		}
+		if !containsString(list, t) {
+			list = append(list, t)
+		}
+	}
+	if len(list) > maxChoreTags {
+		return nil, fmt.Errorf("%w: a chore can have at most %d tags", errBadTags, maxChoreTags)
+	}
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	refs := make([]*firestore.DocumentRef, 0, len(list))
+	for _, t := range list {
+		refs = append(refs, groupRef.Collection("tags").Doc(t))
+	}
+	snaps, err := firestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tags: %w", err)
+	}
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			return nil, fmt.Errorf("%w: unknown tag %q; add it to the group's tags first", errBadTags, snap.Ref.ID)
+		}
+	}
+	return list, nil
+}
+
+func validPriority(p int) bool {
+	return p >= 0 && p <= maxPriority
+}
+
+// updateChoreHandler edits a chore. Only the fields sent are changed, and
+// the edit is recorded in the chore's history as "edited".
+//
+//	PATCH /groups/{groupId}/chores/{choreId} {"user_id", "chore_name", "chore_details", "chore_due_date",
+//	                                          "chore_frequency", "priority", "tags", "estimated_minutes",
+//	                                          "requires_verification", "points"}
+func updateChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID               string    `json:"user_id"`
+		ChoreName            *string   `json:"chore_name"`
+		ChoreDetails         *string   `json:"chore_details"`
+		ChoreDueDate         *string   `json:"chore_due_date"`
+		ChoreFrequency       *string   `json:"chore_frequency"`
+		Priority             *int      `json:"priority"`
+		Tags                 *[]string `json:"tags"`
+		EstimatedMinutes     *int      `json:"estimated_minutes"`
+		RequiresVerification *bool     `json:"requires_verification"` // overrides the group's settings.require_verification
+		Points               *int      `json:"points"`                // 0 works it out from estimated_minutes
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update chore: %v", err), statusForError(err))
+		return err
+	}
+
+	var updates []firestore.Update
+	var changed []string
+	set := func(path string, value interface{}) {
+		updates = append(updates, firestore.Update{Path: path, Value: value})
+		changed = append(changed, path)
+	}
+	bad := func(msg string) error {
+		http.Error(w, msg, http.StatusBadRequest)
+		return errors.New(msg)
+	}
+
+	if req.ChoreName != nil {
+		name := strings.TrimSpace(*req.ChoreName)
+		if name == "" || len(name) > maxChoreNameLen {
+			return bad(fmt.Sprintf("chore_name can't be empty or over %d characters", maxChoreNameLen))
+		}
+		set("chore_name", name)
+	}
+	if req.ChoreDetails != nil {
+		set("chore_details", *req.ChoreDetails)
+	}
+	if req.ChoreDueDate != nil {
+		if _, err := time.Parse(dateLayout, *req.ChoreDueDate); err != nil {
+			return bad(fmt.Sprintf("Invalid chore_due_date %q; use YYYY-MM-DD", *req.ChoreDueDate))
+		}
+		set("chore_due_date", *req.ChoreDueDate)
+		// A new due date is a new schedule, not a snooze
+		d, _ := time.Parse(dateLayout, *req.ChoreDueDate)
+		updates = append(updates,
+			firestore.Update{Path: "schedule_anchor", Value: *req.ChoreDueDate},
+			firestore.Update{Path: "schedule_day", Value: d.Day()},
+		)
+	}
+	if req.ChoreFrequency != nil {
+		if !validFrequency(*req.ChoreFrequency) {
+			return bad(fmt.Sprintf("Invalid chore_frequency %q; use daily, weekly, monthly or once", *req.ChoreFrequency))
+		}
+		set("chore_frequency", *req.ChoreFrequency)
+	}
+	if req.Priority != nil {
+		if !validPriority(*req.Priority) {
+			return bad(fmt.Sprintf("priority must be between 0 and %d", maxPriority))
+		}
+		set("priority", *req.Priority)
+	}
+	if req.EstimatedMinutes != nil {
+		if *req.EstimatedMinutes < 0 || *req.EstimatedMinutes > maxEstimatedMinutes {
+			return bad(fmt.Sprintf("estimated_minutes must be between 0 and %d", maxEstimatedMinutes))
+		}
+		set("estimated_minutes", *req.EstimatedMinutes)
+	}
+	if req.Points != nil {
+		if !validPoints(*req.Points) {
+			return bad(fmt.Sprintf("points must be between 0 and %d", maxChorePoints))
+		}
+		set("points", *req.Points)
+	}
+	if req.RequiresVerification != nil {
+		set("requires_verification", *req.RequiresVerification)
+	}
+	if req.Tags != nil {
+		tags, err := checkTags(ctx, groupSnap.Ref, *req.Tags)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, errBadTags) {
+				code = http.StatusBadRequest
+			}
+			http.Error(w, fmt.Sprintf("Failed to update chore: %v", err), code)
+			return err
+		}
+		set("tags", tags)
+	}
+	if len(updates) == 0 {
+		return bad("Nothing to update")
+	}
+
+	err = changeChore(ctx, groupID, choreID, req.UserID, "edited", "", func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+		return updates, map[string]interface{}{
+			"changed": changed,
+		}, nil
+	})
+	if err != nil {
+		return writeChangeError(w, err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":  fmt.Sprintf("Chore %s updated", choreID),
+		"chore_id": choreID,
+		"changed":  changed,
+	})
+	return nil
+}
+
+// deleteChoreHandler removes a chore. Whoever created it and the group
+// owner can. Its history stays, ending in a "deleted" event, so stats and
+// points still add up; its attachments go with it.
+//
+//	DELETE /groups/{groupId}/chores/{choreId}?user_id=...
+func deleteChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete chore: %v", err), statusForError(err))
+		return err
+	}
+	owner, _ := groupSnap.Data()["created_by"].(string)
+	choreRef := groupSnap.Ref.Collection("chores").Doc(choreID)
+
+	var name string
+	var objects []string
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		objects = nil
+		snap, err := tx.Get(choreRef)
+		if status.Code(err) == codes.NotFound {
+			return errChoreNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chore %s: %w", choreID, err)
+		}
+		if by, _ := snap.Data()["created_by"].(string); by != userID && owner != userID {
+			return errCantDelete
+		}
+		name, _ = snap.Data()["chore_name"].(string)
+		attachments, err := tx.Documents(choreRef.Collection("attachments")).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to read attachments: %w", err)
+		}
+
+		for _, a := range attachments {
+			if object, _ := a.Data()["object"].(string); object != "" {
+				objects = append(objects, object)
+			}
+			if err := tx.Delete(a.Ref); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(choreRef); err != nil {
+			return fmt.Errorf("failed to delete chore %s: %w", choreID, err)
+		}
+		historyRef := choreRef.Collection("history").NewDoc()
+		if err := tx.Create(historyRef, historyDoc(snap, userID, "deleted", "", nil)); err != nil {
+			return err
+		}
+		return tx.Create(groupSnap.Ref.Collection("activity").NewDoc(), activityDoc("chore_deleted", userID, map[string]interface{}{
+			"chore_id":   choreID,
+			"chore_name": name,
+			"history_id": historyRef.ID,
+		}))
+	})
+	if err != nil {
+		code := statusForError(err)
+		if errors.Is(err, errCantDelete) {
+			code = http.StatusForbidden
+		}
+		http.Error(w, fmt.Sprintf("Failed to delete chore: %v", err), code)
+		return err
+	}
+
+	if len(objects) > 0 {
+		if s, err := attachmentStorage(ctx); err != nil {
+			log.Printf("Failed to delete attachment files of chore %s: %v", choreID, err)
+		} else {
+			for _, object := range objects {
+				if err := s.Delete(ctx, object); err != nil {
+					// The chore is gone either way; a stray file is only wasted space
+					log.Printf("Failed to delete attachment file %s: %v", object, err)
+				}
+			}
+		}
+	}
+
+	writeJSON(w, map[string]string{
+		"message":  fmt.Sprintf("Chore %s deleted", choreID),
+		"chore_id": choreID,
+	})
+	return nil
+}
+
+// listTagsHandler lists the group's tag vocabulary.
+//
+//	GET /groups/{groupId}/tags?user_id=...
+func listTagsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list tags: %v", err), statusForError(err))
+		return err
+	}
+	docs, err := groupSnap.Ref.Collection("tags").Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list tags: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	tags := make([]map[string]string, 0, len(docs))
+	for _, doc := range docs {
+		tag := map[string]string{"name": doc.Ref.ID}
+		tag["color"], _ = doc.Data()["color"].(string)
+		tags = append(tags, tag)
+	}
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"tags":     tags,
+	})
+	return nil
+}
+
+// addTagHandler adds a tag to the group's vocabulary.
+//
+//	POST /groups/{groupId}/tags {"user_id", "name", "color": "#RRGGBB"}
+func addTagHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		Name   string `json:"name"`
+		Color  string `json:"color"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" || req.Name == "" {
+		http.Error(w, "user_id and name are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	tag, err := normalizeTag(req.Name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	if req.Color != "" && !colorPattern.MatchString(req.Color) {
+		http.Error(w, fmt.Sprintf("Invalid color %q; use #RRGGBB", req.Color), http.StatusBadRequest)
+		return fmt.Errorf("invalid color")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add tag: %v", err), statusForError(err))
+		return err
+	}
+
+	tags := groupSnap.Ref.Collection("tags")
+	existing, err := tags.Select().Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add tag: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if len(existing) >= maxGroupTags {
+		http.Error(w, fmt.Sprintf("A group can have at most %d tags", maxGroupTags), http.StatusConflict)
+		return fmt.Errorf("too many tags")
+	}
+
+	_, err = tags.Doc(tag).Create(ctx, map[string]interface{}{
+		"name":       tag,
+		"color":      req.Color,
+		"created_by": req.UserID,
+		"created_at": firestore.ServerTimestamp,
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		http.Error(w, fmt.Sprintf("Tag %q already exists", tag), http.StatusConflict)
+		return err
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add tag: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("Tag %s added", tag),
+		"name":    tag,
+		"color":   req.Color,
+	})
+	return nil
+}
+
+// deleteTagHandler removes a tag from the vocabulary and from every chore
+// that has it.
+//
+//	DELETE /groups/{groupId}/tags/{tag}?user_id=...
+func deleteTagHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, tag := r.PathValue("groupId"), r.PathValue("tag")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete tag: %v", err), statusForError(err))
+		return err
+	}
+
+	tagRef := groupSnap.Ref.Collection("tags").Doc(tag)
+	tagged := groupSnap.Ref.Collection("chores").Where("tags", "array-contains", tag)
+	removed := 0
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(tagRef); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return errTagNotFound
+			}
+			return err
+		}
+		docs, err := tx.Documents(tagged).GetAll()
+		if err != nil {
+			return err
+		}
+		removed = len(docs)
+		for _, doc := range docs {
+			if err := tx.Update(doc.Ref, []firestore.Update{
+				{Path: "tags", Value: firestore.ArrayRemove(tag)},
+			}); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(tagRef)
+	})
+	if errors.Is(err, errTagNotFound) {
+		http.Error(w, fmt.Sprintf("Failed to delete tag: %v", err), http.StatusNotFound)
+		return err
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete tag: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":        fmt.Sprintf("Tag %s deleted", tag),
+		"chores_updated": removed,
+	})
+	return nil
+}
@@ -0,0 +1,576 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Every completion, skip, reassignment, claim and edit is recorded in
+
+	/groups/{groupId}/chores/{choreId}/history/{eventId}
+		group_id, chore_id, chore_name,
+		action ("completed" | "submitted" | "rejected" | "skipped" | "reassigned" | "claimed" | "unclaimed" |
+		        "auto_assigned" | "swapped" | "edited" | "snoozed" | "undone" | "deleted"),
+		by, assignee, assignees, at, notes, due_date, on_time, estimated_minutes,
+		from_assignee, to_assignee (reassigned, auto_assigned and swapped),
+		from_assignees (reassigned only), to_assignees (reassigned and swapped),
+		swap_id (swapped only),
+		part, remaining (completing one part of an "all" chore),
+		changed (edited only; the fields that changed),
+		attachment_ids (completed and submitted; proof attached to the chore),
+		verified_by, verification_notes (completed after verification),
+		submitted_by (rejected only),
+		kind ("reminder" | "due_date"), minutes, snoozed_until, from_due_date, to_due_date (snoozed only),
+		points, base_points, days_late, streak_before (completed; see chore-points.go),
+		undone, undone_by (completed events taken back), undoes, completed_by (undone only)
+
+	and summed up on the chore itself: last_completed_at, completed_by,
+	streak_count (on time completions in a row) and missed_count.
+
+	Chores that need verifying wait in chore_status "pending verification"
+	after they are completed; see chore-verification.go.
+
+	Recurring chores (daily, weekly, monthly) move on to their next due date
+	when they are completed or skipped; anything else is closed with
+	chore_status "completed" or "skipped".
+*/
+
+const (
+	maxNotesLength   = 500
+	maxHistoryEvents = 500
+)
+
+var errChoreClosed = errors.New("chore is already completed or skipped")
+
+// historyEvent is one history doc as the API returns it.
+type historyEvent struct {
+	ID           string   `json:"id"`
+	ChoreID      string   `json:"chore_id"`
+	ChoreName    string   `json:"chore_name,omitempty"`
+	Action       string   `json:"action"`
+	By           string   `json:"by"`
+	Assignee     string   `json:"assignee,omitempty"`
+	Assignees    []string `json:"assignees,omitempty"`
+	At           string   `json:"at,omitempty"`
+	Notes        string   `json:"notes,omitempty"`
+	DueDate      string   `json:"due_date,omitempty"`
+	OnTime       *bool    `json:"on_time,omitempty"`
+	Minutes      int64    `json:"estimated_minutes,omitempty"`
+	FromAssignee string   `json:"from_assignee,omitempty"`
+	ToAssignee   string   `json:"to_assignee,omitempty"`
+	Attachments  []string `json:"attachment_ids,omitempty"`
+	VerifiedBy   string   `json:"verified_by,omitempty"`
+	SubmittedBy  string   `json:"submitted_by,omitempty"`
+	ToDueDate    string   `json:"to_due_date,omitempty"`
+	Points       int64    `json:"points,omitempty"`
+	Undone       bool     `json:"undone,omitempty"`
+	Undoes       string   `json:"undoes,omitempty"`
+}
+
+func historyEventFromSnapshot(snap *firestore.DocumentSnapshot) historyEvent {
+	data := snap.Data()
+	e := historyEvent{ID: snap.Ref.ID}
+	e.ChoreID, _ = data["chore_id"].(string)
+	e.ChoreName, _ = data["chore_name"].(string)
+	e.Action, _ = data["action"].(string)
+	e.By, _ = data["by"].(string)
+	e.Assignee, _ = data["assignee"].(string)
+	e.Notes, _ = data["notes"].(string)
+	e.DueDate, _ = data["due_date"].(string)
+	e.FromAssignee, _ = data["from_assignee"].(string)
+	e.ToAssignee, _ = data["to_assignee"].(string)
+	e.Minutes, _ = data["estimated_minutes"].(int64)
+	e.Assignees = stringList(data["assignees"])
+	e.Attachments = stringList(data["attachment_ids"])
+	e.VerifiedBy, _ = data["verified_by"].(string)
+	e.SubmittedBy, _ = data["submitted_by"].(string)
+	e.ToDueDate, _ = data["to_due_date"].(string)
+	e.Points, _ = data["points"].(int64)
+	e.Undone, _ = data["undone"].(bool)
+	e.Undoes, _ = data["undoes"].(string)
+	if t, ok := data["at"].(time.Time); ok {
+		e.At = t.UTC().Format(time.RFC3339)
+	}
+	if v, ok := data["on_time"].(bool); ok {
+		e.OnTime = &v
+	}
+	return e
+}
+
+// choreChange works out what an action does to a chore: the updates for
+// the chore doc and the action specific fields of its history event.
+type choreChange func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error)
+
+// changeChore applies change to the chore and writes its history event in
+// the same transaction, so the chore and its history can't disagree.
+// Completions credit their points there too, and the activity feed gets
+// its entry.
+func changeChore(ctx context.Context, groupID string, choreID string, uid string, action string, notes string, change choreChange) error {
+	choreRef := firestoreClient.Collection("groups").Doc(groupID).Collection("chores").Doc(choreID)
+	return firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(choreRef)
+		if status.Code(err) == codes.NotFound {
+			return errChoreNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chore %s: %w", choreID, err)
+		}
+		data := snap.Data()
+
+		updates, event, err := change(data)
+		if err != nil {
+			return err
+		}
+		// A change can record itself under a different action, as completing
+		// a chore that needs verifying does
+		if renamed, ok := event["action"].(string); ok {
+			action = renamed
+		}
+		updates = append(updates, firestore.Update{Path: "updated_at", Value: firestore.ServerTimestamp})
+		if err := tx.Update(choreRef, updates); err != nil {
+			return fmt.Errorf("failed to update chore %s: %w", choreID, err)
+		}
+
+		historyRef := choreRef.Collection("history").NewDoc()
+		if err := tx.Create(historyRef, historyDoc(snap, uid, action, notes, event)); err != nil {
+			return err
+		}
+		if err := recordChoreActivity(tx, snap, historyRef, uid, action, event); err != nil {
+			return err
+		}
+		return creditPoints(tx, snap, historyRef, uid, action, event)
+	})
+}
+
+// historyDoc fills in the fields every history event has from the chore as
+// it was before the action. event holds the action specific ones.
+func historyDoc(chore *firestore.DocumentSnapshot, uid string, action string, notes string, event map[string]interface{}) map[string]interface{} {
+	if event == nil {
+		event = map[string]interface{}{}
+	}
+	data := chore.Data()
+	event["group_id"] = chore.Ref.Parent.Parent.ID
+	event["chore_id"] = chore.Ref.ID
+	event["chore_name"], _ = data["chore_name"].(string)
+	event["action"] = action
+	event["by"] = uid
+	event["assignee"], _ = data["chore_assignee"].(string)
+	event["assignees"] = choreAssignees(data)
+	event["estimated_minutes"], _ = data["estimated_minutes"].(int64)
+	event["notes"] = notes
+	event["at"] = firestore.ServerTimestamp
+	return event
+}
+
+// closeOrAdvance is the shared tail of completing and skipping: recurring
+// chores get their next due date, one-off chores get closedStatus. Either
+// way the parts done of an "all" chore start over and any snooze ends.
+//
+// The next due date steps from the schedule_anchor, not a snoozed
+// chore_due_date, so snoozing doesn't shift the schedule, and monthly
+// chores keep their schedule_day.
+func closeOrAdvance(chore map[string]interface{}, today string, closedStatus string) []firestore.Update {
+	frequency, _ := chore["chore_frequency"].(string)
+	day := scheduleDay(chore)
+	if next := nextDueDate(scheduleAnchor(chore), frequency, today, day); next != "" {
+		updates := []firestore.Update{
+			{Path: "chore_due_date", Value: next},
+			{Path: "schedule_anchor", Value: next},
+			{Path: "chore_status", Value: "not started"},
+			{Path: "completed_by_members", Value: []string{}},
+			{Path: "snooze", Value: firestore.Delete},
+		}
+		if frequency == "monthly" && day > 0 {
+			// next may be clamped to a short month; the day stays as scheduled
+			updates = append(updates, firestore.Update{Path: "schedule_day", Value: day})
+		}
+		return updates
+	}
+	// A closed chore is nobody's to claim, so the auto-assign job can
+	// forget it
+	return []firestore.Update{
+		{Path: "chore_status", Value: closedStatus},
+		{Path: "completed_by_members", Value: []string{}},
+		{Path: "snooze", Value: firestore.Delete},
+		{Path: "claim_deadline", Value: firestore.Delete},
+	}
+}
+
+func isClosed(chore map[string]interface{}) bool {
+	s, _ := chore["chore_status"].(string)
+	return s == "completed" || s == "skipped"
+}
+
+// choreActionRequest is the body of complete, skip and reassign.
+type choreActionRequest struct {
+	UserID           string   `json:"user_id"`
+	Notes            string   `json:"notes"`
+	Assignee         string   `json:"assignee"`          // reassign only; "" unassigns
+	Assignees        []string `json:"assignees"`         // reassign only; replaces assignee when set
+	CompletionPolicy string   `json:"completion_policy"` // reassign only; "any" or "all", unchanged if empty
+	AttachmentIDs    []string `json:"attachment_ids"`    // complete only; ready attachments on the chore
+}
+
+// readChoreAction decodes the body and checks the caller is in the group.
+func readChoreAction(ctx context.Context, w http.ResponseWriter, r *http.Request) (choreActionRequest, *firestore.DocumentSnapshot, error) {
+	var req choreActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return req, nil, err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return req, nil, fmt.Errorf("missing required fields")
+	}
+	if len(req.Notes) > maxNotesLength {
+		http.Error(w, fmt.Sprintf("notes can be at most %d characters", maxNotesLength), http.StatusBadRequest)
+		return req, nil, fmt.Errorf("notes too long")
+	}
+
+	groupSnap, err := requireGroupMember(ctx, r.PathValue("groupId"), req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update chore: %v", err), statusForError(err))
+		return req, nil, err
+	}
+	return req, groupSnap, nil
+}
+
+func writeChangeError(w http.ResponseWriter, err error) error {
+	code := statusForError(err)
+	switch {
+	case errors.Is(err, errChoreClosed), errors.Is(err, errAlreadyDone),
+		errors.Is(err, errPendingVerification), errors.Is(err, errNotPendingVerification):
+		code = http.StatusConflict
+	case errors.Is(err, errNotAssignee), errors.Is(err, errOwnChore):
+		code = http.StatusForbidden
+	}
+	http.Error(w, fmt.Sprintf("Failed to update chore: %v", err), code)
+	return err
+}
+
+// completeChoreHandler marks a chore done and records who did it. For an
+// "all" chore it marks the caller's part done, and only moves the chore on
+// once every assignee has done theirs.
+func completeChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, groupSnap, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+	today := groupToday(groupSnap)
+
+	if len(req.AttachmentIDs) > 0 {
+		if err := checkAttachments(ctx, groupSnap.Ref.Collection("chores").Doc(choreID), req.AttachmentIDs); err != nil {
+			return writeAttachmentError(w, err)
+		}
+	}
+
+	var onTime, pending bool
+	var streak, minutes int64
+	var remaining []string
+	err = changeChore(ctx, groupID, choreID, req.UserID, "completed", req.Notes, func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+		if isClosed(chore) {
+			return nil, nil, errChoreClosed
+		}
+		if isPendingVerification(chore) {
+			return nil, nil, errPendingVerification
+		}
+		due, _ := chore["chore_due_date"].(string)
+		onTime = due == "" || today <= due
+		streak, _ = chore["streak_count"].(int64)
+		minutes = choreMinutes(chore)
+		points, daysLate := completionPoints(chore, due, today)
+		base := chorePoints(chore)
+		var done []string
+
+		if assignees := choreAssignees(chore); completionPolicy(chore) == policyAll && len(assignees) > 1 {
+			if !containsString(assignees, req.UserID) {
+				return nil, nil, errNotAssignee
+			}
+			done = stringList(chore["completed_by_members"])
+			if containsString(done, req.UserID) {
+				return nil, nil, errAlreadyDone
+			}
+			done = append(done, req.UserID)
+			remaining = remainingAssignees(assignees, done)
+			if len(remaining) > 0 {
+				return []firestore.Update{
+					{Path: "completed_by_members", Value: done},
+				}, withAttachments(map[string]interface{}{
+					"due_date":    due,
+					"on_time":     onTime,
+					"part":        true,
+					"remaining":   remaining,
+					"points":      splitPoints(points, len(assignees)),
+					"base_points": splitPoints(base, len(assignees)),
+					"days_late":   daysLate,
+				}, req.AttachmentIDs), nil
+			}
+		}
+		if pending = needsVerification(groupSnap, chore); pending {
+			if len(done) > 0 {
+				points, base = splitPoints(points, len(done)), splitPoints(base, len(done))
+			}
+			return submitForVerification(chore, req, done, onTime, points, base, daysLate)
+		}
+		streakBefore := streak
+		if onTime {
+			streak++
+		} else {
+			streak = 0
+		}
+
+		updates := append(closeOrAdvance(chore, today, "completed"),
+			firestore.Update{Path: "last_completed_at", Value: firestore.ServerTimestamp},
+			firestore.Update{Path: "completed_by", Value: req.UserID},
+			firestore.Update{Path: "streak_count", Value: streak},
+		)
+		if len(done) > 0 {
+			// The last part of an "all" chore
+			points, base = splitPoints(points, len(done)), splitPoints(base, len(done))
+		}
+		return updates, withAttachments(map[string]interface{}{
+			"due_date":      due,
+			"on_time":       onTime,
+			"streak_before": streakBefore,
+			"points":        points,
+			"base_points":   base,
+			"days_late":     daysLate,
+		}, req.AttachmentIDs), nil
+	})
+	if err != nil {
+		return writeChangeError(w, err)
+	}
+
+	if !pending {
+		workOffMakeUp(ctx, groupSnap, req.UserID, minutes)
+	}
+	if len(remaining) > 0 {
+		writeJSON(w, map[string]interface{}{
+			"message":    fmt.Sprintf("Your part of chore %s is done", choreID),
+			"chore_id":   choreID,
+			"on_time":    onTime,
+			"waiting_on": remaining,
+		})
+		return nil
+	}
+	if pending {
+		notifyVerifiers(ctx, groupSnap, choreID, req.UserID)
+		writeJSON(w, map[string]interface{}{
+			"message":      fmt.Sprintf("Chore %s is waiting for another member to verify it", choreID),
+			"chore_id":     choreID,
+			"on_time":      onTime,
+			"chore_status": statusPendingVerification,
+		})
+		return nil
+	}
+	writeJSON(w, map[string]interface{}{
+		"message":      fmt.Sprintf("Chore %s completed", choreID),
+		"chore_id":     choreID,
+		"on_time":      onTime,
+		"streak_count": streak,
+	})
+	return nil
+}
+
+// skipChoreHandler skips the current occurrence of a chore. It counts as a
+// miss for whoever it was assigned to.
+func skipChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, groupSnap, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+	today := groupToday(groupSnap)
+
+	err = changeChore(ctx, groupID, choreID, req.UserID, "skipped", req.Notes, func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+		if isClosed(chore) {
+			return nil, nil, errChoreClosed
+		}
+		if isPendingVerification(chore) {
+			return nil, nil, errPendingVerification
+		}
+		due, _ := chore["chore_due_date"].(string)
+		missed, _ := chore["missed_count"].(int64)
+
+		updates := append(closeOrAdvance(chore, today, "skipped"),
+			firestore.Update{Path: "missed_count", Value: missed + 1},
+			firestore.Update{Path: "streak_count", Value: 0},
+		)
+		return updates, map[string]interface{}{
+			"due_date": due,
+		}, nil
+	})
+	if err != nil {
+		return writeChangeError(w, err)
+	}
+
+	writeJSON(w, map[string]string{
+		"message":  fmt.Sprintf("Chore %s skipped", choreID),
+		"chore_id": choreID,
+	})
+	return nil
+}
+
+// reassignChoreHandler hands a chore to another member, shares it between
+// several (assignees), or unassigns it.
+func reassignChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, groupSnap, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	if !validPolicy(req.CompletionPolicy) {
+		http.Error(w, fmt.Sprintf("Invalid completion_policy %q; use any or all", req.CompletionPolicy), http.StatusBadRequest)
+		return fmt.Errorf("invalid completion policy")
+	}
+	ids := req.Assignees
+	if ids == nil && req.Assignee != "" {
+		ids = []string{req.Assignee}
+	}
+	assignees, err := checkAssignees(ctx, groupSnap, ids)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errBadAssignees) {
+			code = http.StatusBadRequest
+		}
+		http.Error(w, fmt.Sprintf("Failed to reassign chore: %v", err), code)
+		return err
+	}
+	to := ""
+	if len(assignees) > 0 {
+		to = assignees[0]
+	}
+
+	err = changeChore(ctx, groupID, choreID, req.UserID, "reassigned", req.Notes, func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+		from, _ := chore["chore_assignee"].(string)
+		updates := append(assigneeUpdates(chore, assignees),
+			firestore.Update{Path: "claimed_by", Value: firestore.Delete},
+			firestore.Update{Path: "claimed_at", Value: firestore.Delete},
+			firestore.Update{Path: "claim_deadline", Value: firestore.Delete},
+		)
+		if req.CompletionPolicy != "" {
+			updates = append(updates, firestore.Update{Path: "completion_policy", Value: req.CompletionPolicy})
+		}
+		return updates, map[string]interface{}{
+			"from_assignee":  from,
+			"to_assignee":    to,
+			"from_assignees": choreAssignees(chore),
+			"to_assignees":   assignees,
+		}, nil
+	})
+	if err != nil {
+		return writeChangeError(w, err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":   fmt.Sprintf("Chore %s reassigned", choreID),
+		"chore_id":  choreID,
+		"assignee":  to,
+		"assignees": assignees,
+	})
+	return nil
+}
+
+// historyQuery narrows q to the from / to query params and runs it,
+// newest first.
+func historyQuery(ctx context.Context, w http.ResponseWriter, r *http.Request, groupSnap *firestore.DocumentSnapshot, q firestore.Query) ([]historyEvent, error) {
+	start, end, err := dateRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), groupLocation(groupSnap))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	if !start.IsZero() {
+		q = q.Where("at", ">=", start)
+	}
+	if !end.IsZero() {
+		q = q.Where("at", "<", end)
+	}
+
+	docs, err := q.OrderBy("at", firestore.Desc).Limit(maxHistoryEvents).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read history: %v", err), http.StatusInternalServerError)
+		return nil, err
+	}
+	events := make([]historyEvent, 0, len(docs))
+	for _, doc := range docs {
+		events = append(events, historyEventFromSnapshot(doc))
+	}
+	return events, nil
+}
+
+// choreHistoryHandler lists one chore's history.
+//
+//	GET /groups/{groupId}/chores/{choreId}/history?user_id=...&from=YYYY-MM-DD&to=YYYY-MM-DD
+func choreHistoryHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read history: %v", err), statusForError(err))
+		return err
+	}
+
+	history := groupSnap.Ref.Collection("chores").Doc(choreID).Collection("history")
+	events, err := historyQuery(ctx, w, r, groupSnap, history.Query)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"chore_id": choreID,
+		"history":  events,
+	})
+	return nil
+}
+
+// groupHistoryHandler lists history across every chore in the group,
+// optionally only what one member did.
+//
+//	GET /groups/{groupId}/history?user_id=...&member=...&from=YYYY-MM-DD&to=YYYY-MM-DD
+func groupHistoryHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read history: %v", err), statusForError(err))
+		return err
+	}
+
+	q := firestoreClient.CollectionGroup("history").Where("group_id", "==", groupID)
+	member := r.URL.Query().Get("member")
+	if member != "" {
+		q = q.Where("by", "==", member)
+	}
+	events, err := historyQuery(ctx, w, r, groupSnap, q)
+	if err != nil {
+		return err
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"member":   member,
+		"history":  events,
+	})
+	return nil
+}
@@ -0,0 +1,426 @@
+package chores
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	Bulk import and export, for moving a chore list in from a
+	spreadsheet and for backups.
+
+	Import takes CSV (with a header row) or JSON with the same columns as
+	AddChoreHandler: chore_name, chore_details, chore_due_date,
+	chore_frequency, chore_assignees (";" separated in CSV),
+	estimated_minutes, completion_policy. Every row is checked and gets a
+	result; nothing is written unless every row is valid (or skip_invalid
+	is set), and the valid rows are written in one transaction.
+
+	Export writes the group's chores or history as CSV, or both as JSON.
+*/
+
+const (
+	maxImportRows  = 500
+	maxImportBytes = 1 << 20
+)
+
+var choreColumns = []string{
+	"chore_name", "chore_details", "chore_due_date", "chore_frequency",
+	"chore_assignees", "estimated_minutes", "completion_policy",
+}
+
+// choreRow is one chore to import.
+type choreRow struct {
+	Name             string   `json:"chore_name"`
+	Details          string   `json:"chore_details"`
+	DueDate          string   `json:"chore_due_date"`
+	Frequency        string   `json:"chore_frequency"`
+	Assignees        []string `json:"chore_assignees"`
+	EstimatedMinutes int      `json:"estimated_minutes"`
+	CompletionPolicy string   `json:"completion_policy"`
+}
+
+// rowResult is how one row fared.
+type rowResult struct {
+	Row     int      `json:"row"` // 1 based, not counting the CSV header
+	OK      bool     `json:"ok"`
+	Errors  []string `json:"errors,omitempty"`
+	ChoreID string   `json:"chore_id,omitempty"`
+}
+
+// validate checks a row against the group's members and returns what is
+// wrong with it, if anything.
+func (c *choreRow) validate(members map[string]string) []string {
+	var problems []string
+	if c.Name == "" {
+		problems = append(problems, "chore_name is required")
+	}
+	if _, err := time.Parse(dateLayout, c.DueDate); err != nil {
+		problems = append(problems, fmt.Sprintf("chore_due_date %q is not YYYY-MM-DD", c.DueDate))
+	}
+	if !validFrequency(c.Frequency) {
+		problems = append(problems, fmt.Sprintf("chore_frequency %q must be daily, weekly, monthly or once", c.Frequency))
+	}
+	if c.EstimatedMinutes < 0 || c.EstimatedMinutes > maxEstimatedMinutes {
+		problems = append(problems, fmt.Sprintf("estimated_minutes must be between 0 and %d", maxEstimatedMinutes))
+	}
+	if !validPolicy(c.CompletionPolicy) {
+		problems = append(problems, fmt.Sprintf("completion_policy %q must be any or all", c.CompletionPolicy))
+	}
+	assignees, err := cleanAssignees(c.Assignees)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, uid := range assignees {
+		if _, ok := members[uid]; !ok {
+			problems = append(problems, fmt.Sprintf("assignee %s is not a member of this group", uid))
+		}
+	}
+	c.Assignees = assignees
+	if c.CompletionPolicy == "" {
+		c.CompletionPolicy = policyAny
+	}
+	return problems
+}
+
+// doc is the chore doc AddChoreHandler would have written for the row.
+func (c choreRow) doc(groupID string, uid string) map[string]interface{} {
+	primary := ""
+	if len(c.Assignees) > 0 {
+		primary = c.Assignees[0]
+	}
+	return map[string]interface{}{
+		"created_at":           firestore.ServerTimestamp,
+		"group_id":             groupID,
+		"chore_name":           c.Name,
+		"chore_details":        c.Details,
+		"chore_due_date":       c.DueDate,
+		"chore_frequency":      c.Frequency,
+		"chore_assignee":       primary,
+		"assignees":            c.Assignees,
+		"completion_policy":    c.CompletionPolicy,
+		"completed_by_members": []string{},
+		"estimated_minutes":    c.EstimatedMinutes,
+		"created_by":           uid,
+		"chore_status":         "not started",
+		"completed_at":         "NA",
+		"imported":             true,
+	}
+}
+
+// parseCSVRows reads chores from CSV. Columns are matched by header name,
+// so they can be in any order and extra columns are ignored.
+func parseCSVRows(body io.Reader) ([]choreRow, error) {
+	records, err := csv.NewReader(body).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV needs a header row")
+	}
+	index := make(map[string]int)
+	for i, name := range records[0] {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := index["chore_name"]; !ok {
+		return nil, fmt.Errorf("CSV header must include chore_name")
+	}
+	field := func(record []string, name string) string {
+		if i, ok := index[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	rows := make([]choreRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := choreRow{
+			Name:             field(record, "chore_name"),
+			Details:          field(record, "chore_details"),
+			DueDate:          field(record, "chore_due_date"),
+			Frequency:        field(record, "chore_frequency"),
+			CompletionPolicy: field(record, "completion_policy"),
+		}
+		if a := field(record, "chore_assignees"); a != "" {
+			row.Assignees = strings.Split(a, ";")
+			for i := range row.Assignees {
+				row.Assignees[i] = strings.TrimSpace(row.Assignees[i])
+			}
+		} else if a := field(record, "chore_assignee"); a != "" {
+			row.Assignees = []string{a}
+		}
+		if m := field(record, "estimated_minutes"); m != "" {
+			n, err := strconv.Atoi(m)
+			if err != nil {
+				n = -1 // reported by validate
+			}
+			row.EstimatedMinutes = n
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseJSONRows reads chores from a JSON array or {"chores": [...]}.
+func parseJSONRows(body io.Reader) ([]choreRow, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var rows []choreRow
+	if err := json.Unmarshal(raw, &rows); err == nil {
+		return rows, nil
+	}
+	var wrapped struct {
+		Chores []choreRow `json:"chores"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return wrapped.Chores, nil
+}
+
+// requestFormat is "csv" or "json", from ?format= or the content type.
+func requestFormat(r *http.Request, contentType string) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return strings.ToLower(f)
+	}
+	if strings.Contains(contentType, "csv") {
+		return "csv"
+	}
+	return "json"
+}
+
+// importChoresHandler adds many chores at once.
+//
+//	POST /groups/{groupId}/chores/import?user_id=...&format=csv|json&dry_run=true&skip_invalid=true
+func importChoresHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	skipInvalid := r.URL.Query().Get("skip_invalid") == "true"
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to import chores: %v", err), statusForError(err))
+		return err
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var rows []choreRow
+	switch requestFormat(r, r.Header.Get("Content-Type")) {
+	case "csv":
+		rows, err = parseCSVRows(body)
+	case "json":
+		rows, err = parseJSONRows(body)
+	default:
+		err = fmt.Errorf("format must be csv or json")
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse chores: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if len(rows) == 0 || len(rows) > maxImportRows {
+		http.Error(w, fmt.Sprintf("Import 1 to %d chores at a time", maxImportRows), http.StatusBadRequest)
+		return fmt.Errorf("bad import size %d", len(rows))
+	}
+
+	members, err := groupMembers(ctx, groupSnap)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to import chores: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	results := make([]rowResult, len(rows))
+	invalid := 0
+	for i := range rows {
+		results[i] = rowResult{Row: i + 1, OK: true}
+		if problems := rows[i].validate(members); len(problems) > 0 {
+			results[i].OK = false
+			results[i].Errors = problems
+			invalid++
+		}
+	}
+
+	if dryRun || (invalid > 0 && !skipInvalid) || invalid == len(rows) {
+		w.Header().Set("Content-Type", "application/json")
+		if invalid > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"dry_run":  dryRun,
+			"imported": 0,
+			"invalid":  invalid,
+			"results":  results,
+		})
+		return nil
+	}
+
+	chores := groupSnap.Ref.Collection("chores")
+	var activity []map[string]interface{}
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		activity = activity[:0]
+		for i, row := range rows {
+			results[i].ChoreID = ""
+			if !results[i].OK {
+				continue
+			}
+			ref := chores.NewDoc()
+			if err := tx.Create(ref, row.doc(groupID, userID)); err != nil {
+				return err
+			}
+			results[i].ChoreID = ref.ID
+			activity = append(activity, map[string]interface{}{
+				"chore_id":   ref.ID,
+				"chore_name": row.Name,
+				"assignees":  row.Assignees,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to import chores: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	recordActivities(ctx, groupSnap.Ref, "chore_created", userID, activity)
+
+	writeJSON(w, map[string]interface{}{
+		"dry_run":  false,
+		"imported": len(rows) - invalid,
+		"invalid":  invalid,
+		"results":  results,
+	})
+	return nil
+}
+
+// exportedChore is a chore as export writes it; the import columns plus
+// its id and status.
+type exportedChore struct {
+	ChoreID string `json:"chore_id"`
+	choreRow
+	Status string `json:"chore_status"`
+}
+
+func exportedChoreFromSnapshot(snap *firestore.DocumentSnapshot) exportedChore {
+	data := snap.Data()
+	c := exportedChore{ChoreID: snap.Ref.ID}
+	c.Name, _ = data["chore_name"].(string)
+	c.Details, _ = data["chore_details"].(string)
+	c.DueDate, _ = data["chore_due_date"].(string)
+	c.Frequency, _ = data["chore_frequency"].(string)
+	c.Assignees = choreAssignees(data)
+	minutes, _ := data["estimated_minutes"].(int64)
+	c.EstimatedMinutes = int(minutes)
+	c.CompletionPolicy = completionPolicy(data)
+	c.Status, _ = data["chore_status"].(string)
+	return c
+}
+
+// exportHandler downloads the group's chores and history for backup.
+// CSV holds one table, so ?what=chores (default) or ?what=history picks
+// which; JSON has both.
+//
+//	GET /groups/{groupId}/export?user_id=...&format=csv|json&what=chores|history
+func exportHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	format := requestFormat(r, "")
+	what := r.URL.Query().Get("what")
+	if what == "" {
+		what = "chores"
+	}
+	if (format != "csv" && format != "json") || (what != "chores" && what != "history") {
+		http.Error(w, "format must be csv or json and what chores or history", http.StatusBadRequest)
+		return fmt.Errorf("bad export options")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to export: %v", err), statusForError(err))
+		return err
+	}
+
+	var chores []exportedChore
+	var history []historyEvent
+	if format == "json" || what == "chores" {
+		docs, err := groupSnap.Ref.Collection("chores").OrderBy("chore_due_date", firestore.Asc).Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to export chores: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		chores = make([]exportedChore, 0, len(docs))
+		for _, doc := range docs {
+			chores = append(chores, exportedChoreFromSnapshot(doc))
+		}
+	}
+	if format == "json" || what == "history" {
+		docs, err := firestoreClient.CollectionGroup("history").
+			Where("group_id", "==", groupID).
+			OrderBy("at", firestore.Desc).
+			Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to export history: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		history = make([]historyEvent, 0, len(docs))
+		for _, doc := range docs {
+			history = append(history, historyEventFromSnapshot(doc))
+		}
+	}
+
+	stamp := time.Now().In(groupLocation(groupSnap)).Format(dateLayout)
+	if format == "json" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chores-%s-%s.json"`, groupID, stamp))
+		writeJSON(w, map[string]interface{}{
+			"group_id":    groupID,
+			"exported_at": time.Now().UTC().Format(time.RFC3339),
+			"chores":      chores,
+			"history":     history,
+		})
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s-%s.csv"`, what, groupID, stamp))
+	cw := csv.NewWriter(w)
+	if what == "chores" {
+		_ = cw.Write(append([]string{"chore_id"}, append(choreColumns, "chore_status")...))
+		for _, c := range chores {
+			_ = cw.Write([]string{
+				c.ChoreID, c.Name, c.Details, c.DueDate, c.Frequency,
+				strings.Join(c.Assignees, ";"), strconv.Itoa(c.EstimatedMinutes), c.CompletionPolicy,
+				c.Status,
+			})
+		}
+	} else {
+		_ = cw.Write([]string{"id", "chore_id", "chore_name", "action", "by", "assignee", "at", "due_date", "on_time", "estimated_minutes", "notes"})
+		for _, e := range history {
+			onTime := ""
+			if e.OnTime != nil {
+				onTime = strconv.FormatBool(*e.OnTime)
+			}
+			_ = cw.Write([]string{
+				e.ID, e.ChoreID, e.ChoreName, e.Action, e.By, e.Assignee, e.At,
+				e.DueDate, onTime, strconv.FormatInt(e.Minutes, 10), e.Notes,
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,414 @@
+package chores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Points for getting chores done.
+
+	Every chore is worth points: its points field, or one per 5 estimated
+	minutes (at least 1). Completing it credits the points in the same
+	transaction, as an entry in the group's ledger,
+
+	/groups/{groupId}/points_ledger/{entryId}
+		user_id, chore_id, chore_name, history_id, points, base_points,
+		days_late, reason ("completed" | "reversal"), reverses, reversed, at
+
+	Late completions lose 20% a day, down to a fifth of the points. Each
+	part of an "all" chore earns its share. Undoing a completion writes a
+	reversal entry rather than deleting anything, so the ledger always adds
+	up to everyone's total.
+*/
+
+const (
+	minutesPerPoint    = 5
+	maxChorePoints     = 100
+	latePenaltyPerDay  = 0.2
+	minLateShare       = 0.2
+	maxLedgerEntries   = 200
+	defaultLeaderboard = "week"
+)
+
+var (
+	errNothingToUndo = errors.New("the chore's last action isn't a completion that can be undone")
+	errCantUndo      = errors.New("only whoever completed it or the group owner can undo a completion")
+)
+
+// chorePoints is what the chore is worth on time.
+func chorePoints(chore map[string]interface{}) int64 {
+	if p, _ := chore["points"].(int64); p > 0 {
+		return p
+	}
+	p := int64(math.Ceil(float64(choreMinutes(chore)) / minutesPerPoint))
+	return min(max(p, 1), maxChorePoints)
+}
+
+// completionPoints is what completing the chore on today earns, and how
+// many days late that is.
+func completionPoints(chore map[string]interface{}, due string, today string) (int64, int64) {
+	base := chorePoints(chore)
+	d, err1 := time.Parse(dateLayout, due)
+	t, err2 := time.Parse(dateLayout, today)
+	if err1 != nil || err2 != nil || !t.After(d) {
+		return base, 0
+	}
+	daysLate := int64(t.Sub(d).Hours() / 24)
+	share := math.Max(1-latePenaltyPerDay*float64(daysLate), minLateShare)
+	return max(int64(math.Round(float64(base)*share)), 1), daysLate
+}
+
+// splitPoints is one assignee's share of an "all" chore's points.
+func splitPoints(points int64, assignees int) int64 {
+	if assignees <= 1 {
+		return points
+	}
+	return max(int64(math.Ceil(float64(points)/float64(assignees))), 1)
+}
+
+// creditPoints adds the ledger entry for a completed history event. It
+// only writes, so it can go at the end of the completing transaction.
+func creditPoints(tx *firestore.Transaction, chore *firestore.DocumentSnapshot, historyRef *firestore.DocumentRef, uid string, action string, event map[string]interface{}) error {
+	points, _ := event["points"].(int64)
+	if action != "completed" || points <= 0 || uid == "" {
+		return nil
+	}
+	base, _ := event["base_points"].(int64)
+	daysLate, _ := event["days_late"].(int64)
+	name, _ := chore.Data()["chore_name"].(string)
+	groupRef := chore.Ref.Parent.Parent
+	return tx.Create(groupRef.Collection("points_ledger").NewDoc(), map[string]interface{}{
+		"user_id":     uid,
+		"chore_id":    chore.Ref.ID,
+		"chore_name":  name,
+		"history_id":  historyRef.ID,
+		"points":      points,
+		"base_points": base,
+		"days_late":   daysLate,
+		"reason":      "completed",
+		"at":          firestore.ServerTimestamp,
+	})
+}
+
+// undoCompletionHandler takes back a chore's most recent completion: the
+// chore goes back to how it was, the history event is marked undone and
+// its points are reversed.
+//
+//	POST /groups/{groupId}/chores/{choreId}/undo {"user_id", "notes"}
+func undoCompletionHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	req, groupSnap, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	choreID := r.PathValue("choreId")
+	choreRef := groupSnap.Ref.Collection("chores").Doc(choreID)
+	ledger := groupSnap.Ref.Collection("points_ledger")
+	owner, _ := groupSnap.Data()["created_by"].(string)
+
+	var reversed int64
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		reversed = 0
+		snap, err := tx.Get(choreRef)
+		if status.Code(err) == codes.NotFound {
+			return errChoreNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chore %s: %w", choreID, err)
+		}
+		chore := snap.Data()
+		last, err := tx.Documents(choreRef.Collection("history").OrderBy("at", firestore.Desc).Limit(1)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		if len(last) == 0 {
+			return errNothingToUndo
+		}
+		event := last[0].Data()
+		if action, _ := event["action"].(string); action != "completed" {
+			return errNothingToUndo
+		}
+		by, _ := event["by"].(string)
+		if req.UserID != by && req.UserID != owner {
+			return errCantUndo
+		}
+		entries, err := tx.Documents(ledger.Where("history_id", "==", last[0].Ref.ID)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to read points: %w", err)
+		}
+
+		// Put the chore back
+		due, _ := event["due_date"].(string)
+		updates := []firestore.Update{
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		}
+		assignees := choreAssignees(chore)
+		shared := completionPolicy(chore) == policyAll && len(assignees) > 1
+		if part, _ := event["part"].(bool); part {
+			updates = append(updates, firestore.Update{Path: "completed_by_members", Value: remainingAssignees(stringList(chore["completed_by_members"]), []string{by})})
+		} else {
+			done := []string{}
+			if shared {
+				// Everyone else had done their part before this finished it
+				done = remainingAssignees(assignees, []string{by})
+			}
+			updates = append(updates,
+				firestore.Update{Path: "chore_status", Value: "not started"},
+				firestore.Update{Path: "completed_by_members", Value: done},
+				firestore.Update{Path: "completed_by", Value: firestore.Delete},
+				firestore.Update{Path: "verified_by", Value: firestore.Delete},
+			)
+			if due != "" {
+				updates = append(updates,
+					firestore.Update{Path: "chore_due_date", Value: due},
+					firestore.Update{Path: "schedule_anchor", Value: due},
+				)
+			}
+			if before, ok := event["streak_before"].(int64); ok {
+				updates = append(updates, firestore.Update{Path: "streak_count", Value: before})
+			}
+		}
+		if err := tx.Update(choreRef, updates); err != nil {
+			return fmt.Errorf("failed to update chore %s: %w", choreID, err)
+		}
+		if err := tx.Update(last[0].Ref, []firestore.Update{
+			{Path: "undone", Value: true},
+			{Path: "undone_by", Value: req.UserID},
+		}); err != nil {
+			return err
+		}
+		if err := tx.Create(choreRef.Collection("history").NewDoc(), historyDoc(snap, req.UserID, "undone", req.Notes, map[string]interface{}{
+			"undoes":       last[0].Ref.ID,
+			"due_date":     due,
+			"completed_by": by,
+		})); err != nil {
+			return err
+		}
+
+		// And the points
+		for _, entry := range entries {
+			data := entry.Data()
+			if done, _ := data["reversed"].(bool); done {
+				continue
+			}
+			points, _ := data["points"].(int64)
+			if err := tx.Create(ledger.NewDoc(), map[string]interface{}{
+				"user_id":    data["user_id"],
+				"chore_id":   data["chore_id"],
+				"chore_name": data["chore_name"],
+				"history_id": data["history_id"],
+				"points":     -points,
+				"reason":     "reversal",
+				"reverses":   entry.Ref.ID,
+				"at":         firestore.ServerTimestamp,
+			}); err != nil {
+				return err
+			}
+			if err := tx.Update(entry.Ref, []firestore.Update{{Path: "reversed", Value: true}}); err != nil {
+				return err
+			}
+			reversed += points
+		}
+		return nil
+	})
+	if err != nil {
+		code := statusForError(err)
+		switch {
+		case errors.Is(err, errNothingToUndo):
+			code = http.StatusConflict
+		case errors.Is(err, errCantUndo):
+			code = http.StatusForbidden
+		}
+		http.Error(w, fmt.Sprintf("Failed to undo completion: %v", err), code)
+		return err
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":         fmt.Sprintf("Completion of chore %s undone", choreID),
+		"chore_id":        choreID,
+		"points_reversed": reversed,
+	})
+	return nil
+}
+
+// periodStart is when the current week (from Monday) or month began in
+// the group's timezone.
+func periodStart(groupSnap *firestore.DocumentSnapshot, period string) (time.Time, error) {
+	loc := groupLocation(groupSnap)
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch period {
+	case "week":
+		return today.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7)), nil
+	case "month":
+		return today.AddDate(0, 0, 1-now.Day()), nil
+	case "all":
+		return time.Time{}, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid period %q; use week, month or all", period)
+}
+
+// leaderboardHandler ranks the group's members by points this week or
+// month.
+//
+//	GET /groups/{groupId}/leaderboard?user_id=...&period=week|month|all
+func leaderboardHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = defaultLeaderboard
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get leaderboard: %v", err), statusForError(err))
+		return err
+	}
+	since, err := periodStart(groupSnap, period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	members, err := groupMembers(ctx, groupSnap)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get leaderboard: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	q := groupSnap.Ref.Collection("points_ledger").Query
+	if !since.IsZero() {
+		q = q.Where("at", ">=", since)
+	}
+	docs, err := q.Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get leaderboard: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	type standing struct {
+		Rank        int    `json:"rank"`
+		UserID      string `json:"user_id"`
+		UserName    string `json:"user_name,omitempty"`
+		Points      int64  `json:"points"`
+		Completions int    `json:"completions"`
+	}
+	byID := make(map[string]*standing, len(members))
+	for uid, name := range members {
+		byID[uid] = &standing{UserID: uid, UserName: name}
+	}
+	for _, doc := range docs {
+		data := doc.Data()
+		uid, _ := data["user_id"].(string)
+		s := byID[uid]
+		if s == nil {
+			continue // no longer in the group
+		}
+		// An undone completion and its reversal cancel out, but the reversal
+		// is stamped when it was undone, which can be in a later period
+		// than the completion. Leave both out so no period goes negative.
+		if reversed, _ := data["reversed"].(bool); reversed {
+			continue
+		}
+		reason, _ := data["reason"].(string)
+		if reason == "reversal" {
+			continue
+		}
+		points, _ := data["points"].(int64)
+		s.Points += points
+		if reason == "completed" {
+			s.Completions++
+		}
+	}
+
+	board := make([]standing, 0, len(byID))
+	for _, s := range byID {
+		board = append(board, *s)
+	}
+	sort.Slice(board, func(i, j int) bool {
+		if board[i].Points != board[j].Points {
+			return board[i].Points > board[j].Points
+		}
+		return board[i].UserID < board[j].UserID
+	})
+	for i := range board {
+		// Ties share a rank
+		if i > 0 && board[i].Points == board[i-1].Points {
+			board[i].Rank = board[i-1].Rank
+		} else {
+			board[i].Rank = i + 1
+		}
+	}
+
+	resp := map[string]interface{}{
+		"group_id":    groupID,
+		"period":      period,
+		"leaderboard": board,
+	}
+	if !since.IsZero() {
+		resp["since"] = since.Format(dateLayout)
+	}
+	writeJSON(w, resp)
+	return nil
+}
+
+// pointsLedgerHandler lists the group's latest ledger entries, optionally
+// for one member.
+//
+//	GET /groups/{groupId}/points?user_id=...&member=...
+func pointsLedgerHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list points: %v", err), statusForError(err))
+		return err
+	}
+	q := groupSnap.Ref.Collection("points_ledger").Query
+	if member := r.URL.Query().Get("member"); member != "" {
+		q = q.Where("user_id", "==", member)
+	}
+	docs, err := q.OrderBy("at", firestore.Desc).Limit(maxLedgerEntries).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list points: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	entries := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		entry := doc.Data()
+		entry["id"] = doc.Ref.ID
+		if t, ok := entry["at"].(time.Time); ok {
+			entry["at"] = t.UTC().Format(time.RFC3339)
+		}
+		entries = append(entries, entry)
+	}
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"entries":  entries,
+	})
+	return nil
+}
+
+// validPoints is the range points can be set to; 0 means work it out from
+// estimated_minutes.
+func validPoints(p int) bool {
+	return p >= 0 && p <= maxChorePoints
+}
@@ -0,0 +1,310 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Snoozing and rescheduling.
+
+	A member can snooze a chore's reminders for some minutes, which sets
+
+	snooze: {until, minutes, by}
+
+	on the chore, or push its due date back by some days (or to a given
+	date). Pushing the due date leaves schedule_anchor, the date the chore
+	was scheduled for, alone: completing or skipping steps from the anchor,
+	so a weekly Monday chore stays on Mondays however often it is pushed.
+	Monthly chores also keep schedule_day, the day of the month they were
+	first scheduled for, so one due on the 31st comes back on the 31st
+	after a short month.
+
+	Both are bounded by the group's settings.snooze_limits,
+
+	per_week     due date pushes per member per week (Monday to Sunday
+	             in the group's timezone); 0 turns them off
+	max_days     how far past schedule_anchor a due date can go
+	max_minutes  the longest reminder snooze
+
+	with pushes tracked in /groups/{groupId}/snooze_counts/{userId}
+	{week_start, count}. Nothing sends reminders yet, so reminder snoozes
+	don't use up a member's pushes. A recurring chore also can't be pushed
+	into its next occurrence.
+*/
+
+const (
+	defaultSnoozesPerWeek   = 3
+	defaultSnoozeMaxDays    = 3
+	defaultSnoozeMaxMinutes = 24 * 60
+)
+
+var (
+	errSnoozeLimit  = errors.New("no snoozes left this week")
+	errSnoozeTooFar = errors.New("snooze goes past the limit")
+	errNoDueDate    = errors.New("chore has no due date to push")
+)
+
+// snoozeLimits are a group's settings.snooze_limits with defaults filled in.
+type snoozeLimits struct {
+	PerWeek    int64 `json:"per_week"`
+	MaxDays    int64 `json:"max_days"`
+	MaxMinutes int64 `json:"max_minutes"`
+}
+
+func groupSnoozeLimits(groupSnap *firestore.DocumentSnapshot) snoozeLimits {
+	limits := snoozeLimits{
+		PerWeek:    defaultSnoozesPerWeek,
+		MaxDays:    defaultSnoozeMaxDays,
+		MaxMinutes: defaultSnoozeMaxMinutes,
+	}
+	read := func(field string, into *int64) {
+		if v, err := groupSnap.DataAt("settings.snooze_limits." + field); err == nil {
+			if n, ok := v.(int64); ok {
+				*into = n
+			}
+		}
+	}
+	read("per_week", &limits.PerWeek)
+	read("max_days", &limits.MaxDays)
+	read("max_minutes", &limits.MaxMinutes)
+	return limits
+}
+
+// scheduleAnchor is the date the chore's current occurrence was scheduled
+// for, before any snoozes.
+func scheduleAnchor(chore map[string]interface{}) string {
+	if anchor, _ := chore["schedule_anchor"].(string); anchor != "" {
+		return anchor
+	}
+	due, _ := chore["chore_due_date"].(string)
+	return due
+}
+
+// scheduleDay is the day of the month a monthly chore was first scheduled
+// for. It is kept in schedule_day so clamping to a short month (the 31st
+// to Feb 28) doesn't move every later occurrence; chores from before it
+// existed use their schedule_anchor's day.
+func scheduleDay(chore map[string]interface{}) int {
+	if d, _ := chore["schedule_day"].(int64); d >= 1 && d <= 31 {
+		return int(d)
+	}
+	if t, err := time.Parse(dateLayout, scheduleAnchor(chore)); err == nil {
+		return t.Day()
+	}
+	return 0
+}
+
+// weekStart is the Monday of the week containing t.
+func weekStart(t time.Time) string {
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset).Format(dateLayout)
+}
+
+// latestDueDate is how far the chore's due date may be pushed: max_days
+// past its anchor, and for recurring chores before the next occurrence.
+func latestDueDate(chore map[string]interface{}, limits snoozeLimits) (string, error) {
+	anchor := scheduleAnchor(chore)
+	a, err := time.Parse(dateLayout, anchor)
+	if err != nil {
+		return "", errNoDueDate
+	}
+	latest := a.AddDate(0, 0, int(limits.MaxDays)).Format(dateLayout)
+	frequency, _ := chore["chore_frequency"].(string)
+	if next := nextDueDate(anchor, frequency, anchor, scheduleDay(chore)); next != "" {
+		n, _ := time.Parse(dateLayout, next)
+		if beforeNext := n.AddDate(0, 0, -1).Format(dateLayout); beforeNext < latest {
+			latest = beforeNext
+		}
+	}
+	return latest, nil
+}
+
+// snoozeChoreHandler snoozes a chore's reminders or pushes its due date.
+// Send one of minutes, days or due_date.
+//
+//	POST /groups/{groupId}/chores/{choreId}/snooze {"user_id", "minutes" | "days" | "due_date", "notes"}
+func snoozeChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID  string `json:"user_id"`
+		Minutes int64  `json:"minutes"`
+		Days    int64  `json:"days"`
+		DueDate string `json:"due_date"` // YYYY-MM-DD
+		Notes   string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	sent := 0
+	for _, set := range []bool{req.Minutes != 0, req.Days != 0, req.DueDate != ""} {
+		if set {
+			sent++
+		}
+	}
+	if sent != 1 || req.Minutes < 0 || req.Days < 0 {
+		http.Error(w, "Send one of minutes, days or due_date, and no negative amounts", http.StatusBadRequest)
+		return fmt.Errorf("invalid snooze")
+	}
+	if req.DueDate != "" {
+		if _, err := time.Parse(dateLayout, req.DueDate); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid due_date %q; use YYYY-MM-DD", req.DueDate), http.StatusBadRequest)
+			return err
+		}
+	}
+	if len(req.Notes) > maxNotesLength {
+		http.Error(w, fmt.Sprintf("notes can be at most %d characters", maxNotesLength), http.StatusBadRequest)
+		return fmt.Errorf("notes too long")
+	}
+	groupID, choreID := r.PathValue("groupId"), r.PathValue("choreId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to snooze chore: %v", err), statusForError(err))
+		return err
+	}
+	limits := groupSnoozeLimits(groupSnap)
+	if req.Minutes > limits.MaxMinutes {
+		http.Error(w, fmt.Sprintf("Reminders can be snoozed for at most %d minutes", limits.MaxMinutes), http.StatusBadRequest)
+		return errSnoozeTooFar
+	}
+	now := time.Now().In(groupLocation(groupSnap))
+	week := weekStart(now)
+	choreRef := groupSnap.Ref.Collection("chores").Doc(choreID)
+	countRef := groupSnap.Ref.Collection("snooze_counts").Doc(req.UserID)
+
+	var newDue string
+	var until time.Time
+	var left int64
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(choreRef)
+		if status.Code(err) == codes.NotFound {
+			return errChoreNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chore %s: %w", choreID, err)
+		}
+		chore := snap.Data()
+		if isClosed(chore) {
+			return errChoreClosed
+		}
+		if isPendingVerification(chore) {
+			return errPendingVerification
+		}
+
+		var used int64
+		countSnap, err := tx.Get(countRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return fmt.Errorf("failed to read snooze count: %w", err)
+		}
+		if countSnap.Exists() {
+			if started, _ := countSnap.Data()["week_start"].(string); started == week {
+				used, _ = countSnap.Data()["count"].(int64)
+			}
+		}
+		// Only due date pushes count against per_week
+		pushing := req.Minutes == 0
+		if pushing && used >= limits.PerWeek {
+			return errSnoozeLimit
+		}
+		left = limits.PerWeek - used
+		if pushing {
+			left--
+		}
+
+		due, _ := chore["chore_due_date"].(string)
+		event := map[string]interface{}{"due_date": due}
+		updates := []firestore.Update{
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		}
+		if req.Minutes > 0 {
+			until = time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+			updates = append(updates, firestore.Update{Path: "snooze", Value: map[string]interface{}{
+				"until":   until,
+				"minutes": req.Minutes,
+				"by":      req.UserID,
+			}})
+			event["kind"] = "reminder"
+			event["minutes"] = req.Minutes
+			event["snoozed_until"] = until
+			newDue = due
+		} else {
+			newDue = req.DueDate
+			if req.Days > 0 {
+				d, err := time.Parse(dateLayout, due)
+				if err != nil {
+					return errNoDueDate
+				}
+				newDue = d.AddDate(0, 0, int(req.Days)).Format(dateLayout)
+			}
+			latest, err := latestDueDate(chore, limits)
+			if err != nil {
+				return err
+			}
+			if newDue <= due {
+				return fmt.Errorf("%w: %s isn't after the current due date %s", errSnoozeTooFar, newDue, due)
+			}
+			if newDue > latest {
+				return fmt.Errorf("%w: the latest this chore can be pushed to is %s", errSnoozeTooFar, latest)
+			}
+			updates = append(updates,
+				firestore.Update{Path: "chore_due_date", Value: newDue},
+				firestore.Update{Path: "schedule_anchor", Value: scheduleAnchor(chore)},
+			)
+			event["kind"] = "due_date"
+			event["from_due_date"] = due
+			event["to_due_date"] = newDue
+		}
+
+		if pushing {
+			if err := tx.Set(countRef, map[string]interface{}{
+				"week_start": week,
+				"count":      used + 1,
+				"updated_at": firestore.ServerTimestamp,
+			}); err != nil {
+				return err
+			}
+		}
+		if err := tx.Update(choreRef, updates); err != nil {
+			return fmt.Errorf("failed to update chore %s: %w", choreID, err)
+		}
+		return tx.Create(choreRef.Collection("history").NewDoc(), historyDoc(snap, req.UserID, "snoozed", req.Notes, event))
+	})
+	if err != nil {
+		code := statusForError(err)
+		switch {
+		case errors.Is(err, errSnoozeLimit):
+			code = http.StatusTooManyRequests
+		case errors.Is(err, errSnoozeTooFar), errors.Is(err, errNoDueDate):
+			code = http.StatusBadRequest
+		case errors.Is(err, errChoreClosed), errors.Is(err, errPendingVerification):
+			code = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Failed to snooze chore: %v", err), code)
+		return err
+	}
+
+	resp := map[string]interface{}{
+		"message":        fmt.Sprintf("Chore %s snoozed", choreID),
+		"chore_id":       choreID,
+		"chore_due_date": newDue,
+		"snoozes_left":   left,
+	}
+	if !until.IsZero() {
+		resp["snoozed_until"] = until.UTC().Format(time.RFC3339)
+	}
+	writeJSON(w, resp)
+	return nil
+}
@@ -0,0 +1,287 @@
+package chores
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	Workload and fairness, worked out from the chore history.
+
+	For each member over a window: chores completed, minutes of effort
+	(estimated_minutes, or defaultEffortMinutes for chores without an
+	estimate), on time rate, misses (skips of chores assigned to them, shared
+	or not) and their current on time streak.
+
+	Fairness compares each member's share of the effort with an equal
+	split: fairness_score is share * members, so 1 is a fair share, 0.5 is
+	half of one and 2 is double. The group gets Jain's fairness index,
+	(sum x)^2 / (n * sum x^2), which is 1 when everyone does the same and
+	1/n when one person does everything.
+*/
+
+const (
+	maxEstimatedMinutes  = 24 * 60
+	defaultEffortMinutes = 15
+	defaultStatsWindow   = 30
+	maxStatsWindow       = 365
+)
+
+// memberStats is one member's workload over a window.
+type memberStats struct {
+	UserID        string  `json:"user_id"`
+	UserName      string  `json:"user_name,omitempty"`
+	Completed     int     `json:"completed"`
+	OnTime        int     `json:"on_time"`
+	OnTimeRate    float64 `json:"on_time_rate"`
+	Minutes       int64   `json:"minutes"`
+	Missed        int     `json:"missed"`
+	CurrentStreak int     `json:"current_streak"`
+	Share         float64 `json:"share"`
+	FairnessScore float64 `json:"fairness_score"`
+}
+
+// workload is every member's stats plus the group's fairness index.
+type workload struct {
+	Since         string         `json:"since,omitempty"`
+	Members       []*memberStats `json:"members"`
+	FairnessIndex float64        `json:"fairness_index"`
+}
+
+// parseStatsWindow reads "7d", "30", "all", ... into a number of days;
+// 0 means all time.
+func parseStatsWindow(s string) (int, error) {
+	if s == "" {
+		return defaultStatsWindow, nil
+	}
+	if s == "all" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+	if err != nil || days < 1 || days > maxStatsWindow {
+		return 0, fmt.Errorf("invalid window %q; use 1d to %dd or all", s, maxStatsWindow)
+	}
+	return days, nil
+}
+
+// groupMembers returns the owner and everyone in members, with user_name
+// where there is one.
+func groupMembers(ctx context.Context, groupSnap *firestore.DocumentSnapshot) (map[string]string, error) {
+	members := make(map[string]string)
+	if owner, _ := groupSnap.Data()["created_by"].(string); owner != "" {
+		members[owner] = ""
+	}
+	docs, err := groupSnap.Ref.Collection("members").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read members: %w", err)
+	}
+	for _, doc := range docs {
+		members[doc.Ref.ID], _ = doc.Data()["user_name"].(string)
+	}
+	return members, nil
+}
+
+// computeWorkload works out every member's workload over the last days
+// days (0 for all time).
+func computeWorkload(ctx context.Context, groupSnap *firestore.DocumentSnapshot, days int) (*workload, error) {
+	members, err := groupMembers(ctx, groupSnap)
+	if err != nil {
+		return nil, err
+	}
+
+	wl := &workload{}
+	stats := make(map[string]*memberStats, len(members))
+	for uid, name := range members {
+		s := &memberStats{UserID: uid, UserName: name}
+		stats[uid] = s
+		wl.Members = append(wl.Members, s)
+	}
+	sort.Slice(wl.Members, func(i, j int) bool { return wl.Members[i].UserID < wl.Members[j].UserID })
+
+	q := firestoreClient.CollectionGroup("history").Where("group_id", "==", groupSnap.Ref.ID)
+	if days > 0 {
+		loc := groupLocation(groupSnap)
+		now := time.Now().In(loc)
+		since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1-days)
+		wl.Since = since.Format(dateLayout)
+		q = q.Where("at", ">=", since)
+	}
+	docs, err := q.OrderBy("at", firestore.Desc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	// Newest first, so a streak is counted until the first late completion
+	// or miss
+	streakOver := make(map[string]bool)
+	for _, doc := range docs {
+		e := historyEventFromSnapshot(doc)
+		if e.Undone {
+			continue
+		}
+		switch e.Action {
+		case "completed":
+			s := stats[e.By]
+			if s == nil {
+				continue // no longer in the group
+			}
+			s.Completed++
+			minutes := e.Minutes
+			if minutes <= 0 {
+				minutes = defaultEffortMinutes
+			}
+			s.Minutes += minutes
+			onTime := e.OnTime == nil || *e.OnTime
+			if onTime {
+				s.OnTime++
+			}
+			if !onTime {
+				streakOver[e.By] = true
+			} else if !streakOver[e.By] {
+				s.CurrentStreak++
+			}
+		case "skipped":
+			assignees := e.Assignees
+			if len(assignees) == 0 {
+				assignees = []string{e.Assignee}
+			}
+			for _, uid := range assignees {
+				if s := stats[uid]; s != nil {
+					s.Missed++
+					streakOver[uid] = true
+				}
+			}
+		}
+	}
+
+	var total, sumSquares float64
+	for _, s := range wl.Members {
+		if s.Completed > 0 {
+			s.OnTimeRate = round2(float64(s.OnTime) / float64(s.Completed))
+		}
+		total += float64(s.Minutes)
+		sumSquares += float64(s.Minutes) * float64(s.Minutes)
+	}
+	n := float64(len(wl.Members))
+	wl.FairnessIndex = 1
+	for _, s := range wl.Members {
+		s.FairnessScore = 1
+		if total > 0 {
+			s.Share = round2(float64(s.Minutes) / total)
+			s.FairnessScore = round2(float64(s.Minutes) / total * n)
+		}
+	}
+	if sumSquares > 0 {
+		wl.FairnessIndex = round2(total * total / (n * sumSquares))
+	}
+	return wl, nil
+}
+
+// assignmentLoads is each member's workload over the default window as
+// assignment sees it: make_up_minutes still owed count against their
+// minutes, so members making up for time away get more.
+func assignmentLoads(ctx context.Context, groupSnap *firestore.DocumentSnapshot) (map[string]memberStats, error) {
+	wl, err := computeWorkload(ctx, groupSnap, defaultStatsWindow)
+	if err != nil {
+		return nil, err
+	}
+	owed, err := owedMinutes(ctx, groupSnap)
+	if err != nil {
+		return nil, err
+	}
+	loads := make(map[string]memberStats, len(wl.Members))
+	for _, s := range wl.Members {
+		m := *s
+		m.Minutes -= owed[m.UserID]
+		loads[m.UserID] = m
+	}
+	return loads, nil
+}
+
+// lightest picks whoever among candidates has the least load. Ties go to
+// fewer completions, then the lowest uid.
+func lightest(loads map[string]memberStats, candidates []string) string {
+	best := ""
+	var bestStats memberStats
+	for _, uid := range candidates {
+		s, ok := loads[uid]
+		if !ok {
+			s = memberStats{UserID: uid}
+		}
+		if best == "" ||
+			s.Minutes < bestStats.Minutes ||
+			(s.Minutes == bestStats.Minutes && s.Completed < bestStats.Completed) ||
+			(s.Minutes == bestStats.Minutes && s.Completed == bestStats.Completed && uid < best) {
+			best, bestStats = uid, s
+		}
+	}
+	return best
+}
+
+// leastLoadedMember picks whoever among candidates has put in the least
+// effort over the default window, so rotations and auto-assignment even
+// things out.
+func leastLoadedMember(ctx context.Context, groupSnap *firestore.DocumentSnapshot, candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	loads, err := assignmentLoads(ctx, groupSnap)
+	if err != nil {
+		return "", err
+	}
+	return lightest(loads, candidates), nil
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// choreStatsHandler returns each member's workload and the fairness scores.
+//
+//	GET /groups/{groupId}/stats?user_id=...&window=7d|30d|90d|all
+func choreStatsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	days, err := parseStatsWindow(r.URL.Query().Get("window"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get stats: %v", err), statusForError(err))
+		return err
+	}
+
+	wl, err := computeWorkload(ctx, groupSnap, days)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get stats: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	window := "all"
+	if days > 0 {
+		window = fmt.Sprintf("%dd", days)
+	}
+	writeJSON(w, map[string]interface{}{
+		"group_id":       groupID,
+		"window":         window,
+		"since":          wl.Since,
+		"members":        wl.Members,
+		"fairness_index": wl.FairnessIndex,
+	})
+	return nil
+}
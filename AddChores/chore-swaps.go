@@ -0,0 +1,521 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Swaps: when someone can't do their chore they can trade it.
+
+	/groups/{groupId}/swap_requests/{swapId}
+		from_user, to_user,
+		from_chore_id (the proposer's chore), to_chore_id (the other
+		member's chore, "" for a plain handoff),
+		status ("pending" | "accepted" | "declined" | "cancelled"),
+		notes, created_at, expires_at, responded_at
+
+	Accepting flips both assignments in one transaction and writes a
+	"swapped" history event on each chore. Both people are notified in
+	the app when a swap is proposed, accepted or declined.
+*/
+
+const swapExpiry = 7 * 24 * time.Hour
+
+var (
+	errSwapNotFound   = errors.New("swap request not found")
+	errSwapNotPending = errors.New("swap request is no longer pending")
+	errSwapExpired    = errors.New("swap request has expired")
+	errSwapNotYours   = errors.New("this swap request is not yours to answer")
+	errSwapStale      = errors.New("the chores have changed since the swap was proposed")
+	errSwapPending    = errors.New("there is already a pending swap for this chore")
+	errBadSwap        = errors.New("invalid swap")
+)
+
+// swapView is a swap request as the API returns it.
+type swapView struct {
+	ID          string `json:"swap_id"`
+	FromUser    string `json:"from_user"`
+	ToUser      string `json:"to_user"`
+	FromChoreID string `json:"from_chore_id"`
+	ToChoreID   string `json:"to_chore_id,omitempty"`
+	Status      string `json:"status"`
+	Notes       string `json:"notes,omitempty"`
+	CreatedAt   string `json:"created_at,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	RespondedAt string `json:"responded_at,omitempty"`
+}
+
+func swapViewFromSnapshot(snap *firestore.DocumentSnapshot) swapView {
+	data := snap.Data()
+	v := swapView{ID: snap.Ref.ID}
+	v.FromUser, _ = data["from_user"].(string)
+	v.ToUser, _ = data["to_user"].(string)
+	v.FromChoreID, _ = data["from_chore_id"].(string)
+	v.ToChoreID, _ = data["to_chore_id"].(string)
+	v.Status, _ = data["status"].(string)
+	v.Notes, _ = data["notes"].(string)
+	if t, ok := data["created_at"].(time.Time); ok {
+		v.CreatedAt = t.UTC().Format(time.RFC3339)
+	}
+	if t, ok := data["expires_at"].(time.Time); ok {
+		v.ExpiresAt = t.UTC().Format(time.RFC3339)
+	}
+	if t, ok := data["responded_at"].(time.Time); ok {
+		v.RespondedAt = t.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+func writeSwapError(w http.ResponseWriter, err error) error {
+	code := statusForError(err)
+	switch {
+	case errors.Is(err, errSwapNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errSwapNotYours):
+		code = http.StatusForbidden
+	case errors.Is(err, errBadSwap):
+		code = http.StatusBadRequest
+	case errors.Is(err, errSwapNotPending), errors.Is(err, errSwapExpired),
+		errors.Is(err, errSwapStale), errors.Is(err, errSwapPending),
+		errors.Is(err, errChoreClosed):
+		code = http.StatusConflict
+	}
+	http.Error(w, fmt.Sprintf("Failed to swap chores: %v", err), code)
+	return err
+}
+
+// replaceAssignee swaps from for to in a chore's assignees.
+func replaceAssignee(assignees []string, from string, to string) []string {
+	list := make([]string, 0, len(assignees))
+	for _, uid := range assignees {
+		if uid == from {
+			uid = to
+		}
+		list = append(list, uid)
+	}
+	return list
+}
+
+// checkSwappable makes sure uid holds the chore and other doesn't already.
+func checkSwappable(chore map[string]interface{}, uid string, other string) error {
+	if isClosed(chore) {
+		return errChoreClosed
+	}
+	assignees := choreAssignees(chore)
+	if !containsString(assignees, uid) || containsString(assignees, other) {
+		return errSwapStale
+	}
+	return nil
+}
+
+// choreName reads a chore's name for notifications, or "" if it can't.
+func choreName(ctx context.Context, groupSnap *firestore.DocumentSnapshot, choreID string) string {
+	if choreID == "" {
+		return ""
+	}
+	snap, err := groupSnap.Ref.Collection("chores").Doc(choreID).Get(ctx)
+	if err != nil {
+		return ""
+	}
+	name, _ := snap.Data()["chore_name"].(string)
+	return name
+}
+
+// proposeSwapHandler asks another member to take the caller's chore, in
+// exchange for one of theirs or as a plain handoff.
+//
+//	POST /groups/{groupId}/swaps {"user_id", "to_user", "chore_id", "their_chore_id", "notes"}
+func proposeSwapHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID       string `json:"user_id"`
+		ToUser       string `json:"to_user"`
+		ChoreID      string `json:"chore_id"`
+		TheirChoreID string `json:"their_chore_id"` // optional; "" is a handoff
+		Notes        string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" || req.ToUser == "" || req.ChoreID == "" {
+		http.Error(w, "user_id, to_user and chore_id are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	if req.ToUser == req.UserID {
+		http.Error(w, "You can't swap with yourself", http.StatusBadRequest)
+		return fmt.Errorf("swap with self")
+	}
+	if req.TheirChoreID == req.ChoreID {
+		http.Error(w, "chore_id and their_chore_id must be different chores", http.StatusBadRequest)
+		return fmt.Errorf("swap of one chore with itself")
+	}
+	if len(req.Notes) > maxNotesLength {
+		http.Error(w, fmt.Sprintf("notes can be at most %d characters", maxNotesLength), http.StatusBadRequest)
+		return fmt.Errorf("notes too long")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to propose swap: %v", err), statusForError(err))
+		return err
+	}
+	ok, err := isGroupMember(ctx, groupSnap, req.ToUser)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to check to_user: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("%s is not a member of this group", req.ToUser), http.StatusBadRequest)
+		return errNotGroupMember
+	}
+
+	chores := groupSnap.Ref.Collection("chores")
+	swaps := groupSnap.Ref.Collection("swap_requests")
+	swapRef := swaps.NewDoc()
+	expiresAt := time.Now().Add(swapExpiry)
+
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		mine, err := tx.Get(chores.Doc(req.ChoreID))
+		if status.Code(err) == codes.NotFound {
+			return errChoreNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chore %s: %w", req.ChoreID, err)
+		}
+		if err := checkSwappable(mine.Data(), req.UserID, req.ToUser); err != nil {
+			if errors.Is(err, errSwapStale) {
+				return fmt.Errorf("%w: chore %s must be assigned to you and not to %s", errBadSwap, req.ChoreID, req.ToUser)
+			}
+			return err
+		}
+		if req.TheirChoreID != "" {
+			theirs, err := tx.Get(chores.Doc(req.TheirChoreID))
+			if status.Code(err) == codes.NotFound {
+				return errChoreNotFound
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read chore %s: %w", req.TheirChoreID, err)
+			}
+			if err := checkSwappable(theirs.Data(), req.ToUser, req.UserID); err != nil {
+				if errors.Is(err, errSwapStale) {
+					return fmt.Errorf("%w: chore %s must be assigned to %s and not to you", errBadSwap, req.TheirChoreID, req.ToUser)
+				}
+				return err
+			}
+		}
+
+		pending, err := tx.Documents(swaps.
+			Where("from_chore_id", "==", req.ChoreID).
+			Where("status", "==", "pending")).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to check pending swaps: %w", err)
+		}
+		for _, doc := range pending {
+			if t, ok := doc.Data()["expires_at"].(time.Time); !ok || time.Now().Before(t) {
+				return errSwapPending
+			}
+		}
+
+		return tx.Create(swapRef, map[string]interface{}{
+			"group_id":      groupID,
+			"from_user":     req.UserID,
+			"to_user":       req.ToUser,
+			"from_chore_id": req.ChoreID,
+			"to_chore_id":   req.TheirChoreID,
+			"status":        "pending",
+			"notes":         req.Notes,
+			"created_at":    firestore.ServerTimestamp,
+			"expires_at":    expiresAt,
+		})
+	})
+	if err != nil {
+		return writeSwapError(w, err)
+	}
+
+	body := fmt.Sprintf("Can you take %q?", choreName(ctx, groupSnap, req.ChoreID))
+	if req.TheirChoreID != "" {
+		body = fmt.Sprintf("Swap %q for your %q?", choreName(ctx, groupSnap, req.ChoreID), choreName(ctx, groupSnap, req.TheirChoreID))
+	}
+	queueNotification(ctx, groupSnap, notification{
+		Type:  "swap_requested",
+		To:    req.ToUser,
+		Title: "Chore swap request",
+		Body:  body,
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"message":    "Swap request sent",
+		"swap_id":    swapRef.ID,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	})
+	return nil
+}
+
+// respondToSwap reads the swap in tx and checks it is still pending and
+// that uid is allowed to act on it.
+func respondToSwap(tx *firestore.Transaction, swapRef *firestore.DocumentRef, uid string, byProposer bool) (map[string]interface{}, error) {
+	snap, err := tx.Get(swapRef)
+	if status.Code(err) == codes.NotFound {
+		return nil, errSwapNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swap request %s: %w", swapRef.ID, err)
+	}
+	swap := snap.Data()
+	party := "to_user"
+	if byProposer {
+		party = "from_user"
+	}
+	if u, _ := swap[party].(string); u != uid {
+		return nil, errSwapNotYours
+	}
+	if s, _ := swap["status"].(string); s != "pending" {
+		return nil, errSwapNotPending
+	}
+	if t, ok := swap["expires_at"].(time.Time); ok && time.Now().After(t) {
+		return nil, errSwapExpired
+	}
+	return swap, nil
+}
+
+// readSwapAction decodes {"user_id"} and checks the caller is in the group.
+func readSwapAction(ctx context.Context, w http.ResponseWriter, r *http.Request) (string, *firestore.DocumentSnapshot, error) {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return "", nil, err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return "", nil, fmt.Errorf("missing required fields")
+	}
+	groupSnap, err := requireGroupMember(ctx, r.PathValue("groupId"), req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update swap request: %v", err), statusForError(err))
+		return "", nil, err
+	}
+	return req.UserID, groupSnap, nil
+}
+
+// acceptSwapHandler flips both chores' assignments and records the swap in
+// both histories, all in one transaction.
+//
+//	POST /groups/{groupId}/swaps/{swapId}/accept {"user_id"}
+func acceptSwapHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID, groupSnap, err := readSwapAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	swapID := r.PathValue("swapId")
+	swapRef := groupSnap.Ref.Collection("swap_requests").Doc(swapID)
+	chores := groupSnap.Ref.Collection("chores")
+
+	var fromUser string
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		swap, err := respondToSwap(tx, swapRef, userID, false)
+		if err != nil {
+			return err
+		}
+		fromUser, _ = swap["from_user"].(string)
+		fromChoreID, _ := swap["from_chore_id"].(string)
+		toChoreID, _ := swap["to_chore_id"].(string)
+		notes, _ := swap["notes"].(string)
+
+		// Every read has to happen before the first write
+		refs := []*firestore.DocumentRef{chores.Doc(fromChoreID)}
+		if toChoreID != "" {
+			refs = append(refs, chores.Doc(toChoreID))
+		}
+		snaps, err := tx.GetAll(refs)
+		if err != nil {
+			return fmt.Errorf("failed to read chores: %w", err)
+		}
+		for _, snap := range snaps {
+			if !snap.Exists() {
+				return fmt.Errorf("%w: %w", errSwapStale, errChoreNotFound)
+			}
+		}
+
+		// The proposer's chore goes to the caller, and the caller's (if
+		// any) to the proposer
+		type flip struct {
+			snap     *firestore.DocumentSnapshot
+			from, to string
+		}
+		flips := []flip{{snaps[0], fromUser, userID}}
+		if toChoreID != "" {
+			flips = append(flips, flip{snaps[1], userID, fromUser})
+		}
+		for _, f := range flips {
+			if err := checkSwappable(f.snap.Data(), f.from, f.to); err != nil {
+				return err
+			}
+		}
+		for _, f := range flips {
+			data := f.snap.Data()
+			assignees := replaceAssignee(choreAssignees(data), f.from, f.to)
+			updates := append(assigneeUpdates(data, assignees),
+				firestore.Update{Path: "claimed_by", Value: firestore.Delete},
+				firestore.Update{Path: "claimed_at", Value: firestore.Delete},
+				firestore.Update{Path: "updated_at", Value: firestore.ServerTimestamp},
+			)
+			if err := tx.Update(f.snap.Ref, updates); err != nil {
+				return fmt.Errorf("failed to update chore %s: %w", f.snap.Ref.ID, err)
+			}
+			event := historyDoc(f.snap, userID, "swapped", notes, map[string]interface{}{
+				"swap_id":       swapID,
+				"from_assignee": f.from,
+				"to_assignee":   f.to,
+				"to_assignees":  assignees,
+			})
+			historyRef := f.snap.Ref.Collection("history").NewDoc()
+			if err := tx.Create(historyRef, event); err != nil {
+				return err
+			}
+			if err := recordChoreActivity(tx, f.snap, historyRef, userID, "swapped", event); err != nil {
+				return err
+			}
+		}
+
+		return tx.Update(swapRef, []firestore.Update{
+			{Path: "status", Value: "accepted"},
+			{Path: "responded_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return writeSwapError(w, err)
+	}
+
+	queueNotification(ctx, groupSnap, notification{
+		Type:  "swap_accepted",
+		To:    fromUser,
+		Title: "Swap accepted",
+		Body:  "Your chore swap request was accepted.",
+	})
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("Swap %s accepted", swapID),
+		"swap_id": swapID,
+	})
+	return nil
+}
+
+// declineSwapHandler turns a swap down; only the other member can.
+//
+//	POST /groups/{groupId}/swaps/{swapId}/decline {"user_id"}
+func declineSwapHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return closeSwap(ctx, w, r, false)
+}
+
+// cancelSwapHandler withdraws a swap; only the proposer can.
+//
+//	POST /groups/{groupId}/swaps/{swapId}/cancel {"user_id"}
+func cancelSwapHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return closeSwap(ctx, w, r, true)
+}
+
+func closeSwap(ctx context.Context, w http.ResponseWriter, r *http.Request, cancel bool) error {
+	userID, groupSnap, err := readSwapAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	swapID := r.PathValue("swapId")
+	swapRef := groupSnap.Ref.Collection("swap_requests").Doc(swapID)
+
+	newStatus := "declined"
+	if cancel {
+		newStatus = "cancelled"
+	}
+
+	var fromUser string
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		swap, err := respondToSwap(tx, swapRef, userID, cancel)
+		if err != nil {
+			return err
+		}
+		fromUser, _ = swap["from_user"].(string)
+		return tx.Update(swapRef, []firestore.Update{
+			{Path: "status", Value: newStatus},
+			{Path: "responded_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return writeSwapError(w, err)
+	}
+
+	if !cancel {
+		queueNotification(ctx, groupSnap, notification{
+			Type:  "swap_declined",
+			To:    fromUser,
+			Title: "Swap declined",
+			Body:  "Your chore swap request was declined.",
+		})
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("Swap %s %s", swapID, newStatus),
+		"swap_id": swapID,
+	})
+	return nil
+}
+
+// listSwapsHandler lists the swap requests the caller sent or received,
+// newest first. status narrows it down, e.g. status=pending.
+//
+//	GET /groups/{groupId}/swaps?user_id=...&status=...
+func listSwapsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list swaps: %v", err), statusForError(err))
+		return err
+	}
+
+	swaps := groupSnap.Ref.Collection("swap_requests")
+	filter := r.URL.Query().Get("status")
+	now := time.Now()
+	result := []swapView{}
+	for _, field := range []string{"from_user", "to_user"} {
+		docs, err := swaps.Where(field, "==", userID).Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list swaps: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		for _, doc := range docs {
+			v := swapViewFromSnapshot(doc)
+			if t, ok := doc.Data()["expires_at"].(time.Time); ok && v.Status == "pending" && now.After(t) {
+				v.Status = "expired"
+			}
+			if filter != "" && v.Status != filter {
+				continue
+			}
+			result = append(result, v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt > result[j].CreatedAt })
+
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"swaps":    result,
+	})
+	return nil
+}
@@ -0,0 +1,472 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Templates: lists of chores a group can add in one go.
+
+	Built in packs are below. Groups can save their own in
+
+	/groups/{groupId}/chore_templates/{templateId}
+		name, description, chores [{chore_name, chore_details,
+		chore_frequency, estimated_minutes}], created_by, created_at
+
+	Applying a pack or template creates every chore in it, due on
+	start_date (today by default), handed out round robin across the
+	current members starting with whoever has the lightest workload.
+*/
+
+const (
+	maxTemplateChores  = 50
+	maxTemplateNameLen = 100
+	maxGroupTemplates  = 50
+)
+
+var (
+	errTemplateNotFound = errors.New("template not found")
+	errBadTemplate      = errors.New("invalid template")
+)
+
+// templateChore is one chore in a pack or template.
+type templateChore struct {
+	Name             string `json:"chore_name" firestore:"chore_name"`
+	Details          string `json:"chore_details,omitempty" firestore:"chore_details"`
+	Frequency        string `json:"chore_frequency" firestore:"chore_frequency"`
+	EstimatedMinutes int    `json:"estimated_minutes,omitempty" firestore:"estimated_minutes"`
+}
+
+// choreTemplate is a built in pack or a group's saved template.
+type choreTemplate struct {
+	ID          string          `json:"id" firestore:"-"`
+	Name        string          `json:"name" firestore:"name"`
+	Description string          `json:"description,omitempty" firestore:"description"`
+	Chores      []templateChore `json:"chores" firestore:"chores"`
+	CreatedBy   string          `json:"created_by,omitempty" firestore:"created_by"`
+	BuiltIn     bool            `json:"built_in" firestore:"-"`
+}
+
+var starterPacks = []choreTemplate{
+	{
+		ID:          "apartment_2br",
+		Name:        "2-bedroom apartment basics",
+		Description: "The usual for two people sharing a kitchen and a bathroom.",
+		Chores: []templateChore{
+			{Name: "Take out trash", Details: "Trash and recycling", Frequency: "weekly", EstimatedMinutes: 10},
+			{Name: "Do the dishes", Frequency: "daily", EstimatedMinutes: 15},
+			{Name: "Clean bathroom", Details: "Toilet, sink, shower and mirror", Frequency: "weekly", EstimatedMinutes: 40},
+			{Name: "Vacuum common areas", Frequency: "weekly", EstimatedMinutes: 25},
+			{Name: "Wipe kitchen counters and stove", Frequency: "weekly", EstimatedMinutes: 15},
+			{Name: "Clean out fridge", Details: "Throw out anything expired", Frequency: "monthly", EstimatedMinutes: 20},
+		},
+	},
+	{
+		ID:          "shared_house",
+		Name:        "Shared house",
+		Description: "More rooms, more people and a yard.",
+		Chores: []templateChore{
+			{Name: "Take out trash", Details: "Trash, recycling and compost", Frequency: "weekly", EstimatedMinutes: 15},
+			{Name: "Put bins out for collection", Frequency: "weekly", EstimatedMinutes: 5},
+			{Name: "Do the dishes", Frequency: "daily", EstimatedMinutes: 20},
+			{Name: "Clean bathrooms", Frequency: "weekly", EstimatedMinutes: 60},
+			{Name: "Vacuum and mop", Details: "Living room, hallway and stairs", Frequency: "weekly", EstimatedMinutes: 45},
+			{Name: "Clean kitchen", Details: "Counters, stove, microwave and sink", Frequency: "weekly", EstimatedMinutes: 30},
+			{Name: "Mow the lawn", Frequency: "weekly", EstimatedMinutes: 45},
+			{Name: "Restock shared supplies", Details: "Toilet paper, soap, sponges", Frequency: "monthly", EstimatedMinutes: 30},
+		},
+	},
+	{
+		ID:          "studio_couple",
+		Name:        "Studio for two",
+		Description: "A short list for one room and a kitchenette.",
+		Chores: []templateChore{
+			{Name: "Do the dishes", Frequency: "daily", EstimatedMinutes: 10},
+			{Name: "Take out trash", Frequency: "weekly", EstimatedMinutes: 5},
+			{Name: "Laundry", Frequency: "weekly", EstimatedMinutes: 30},
+			{Name: "Clean bathroom", Frequency: "weekly", EstimatedMinutes: 30},
+			{Name: "Change bed sheets", Frequency: "weekly", EstimatedMinutes: 10},
+		},
+	},
+}
+
+func validFrequency(f string) bool {
+	return f == "daily" || f == "weekly" || f == "monthly" || f == "once"
+}
+
+func (t choreTemplate) validate() error {
+	if t.Name == "" || len(t.Name) > maxTemplateNameLen {
+		return fmt.Errorf("%w: name is required and can be at most %d characters", errBadTemplate, maxTemplateNameLen)
+	}
+	if len(t.Chores) == 0 || len(t.Chores) > maxTemplateChores {
+		return fmt.Errorf("%w: a template needs 1 to %d chores", errBadTemplate, maxTemplateChores)
+	}
+	for i, c := range t.Chores {
+		if c.Name == "" {
+			return fmt.Errorf("%w: chores[%d].chore_name is required", errBadTemplate, i)
+		}
+		if !validFrequency(c.Frequency) {
+			return fmt.Errorf("%w: chores[%d].chore_frequency must be daily, weekly, monthly or once", errBadTemplate, i)
+		}
+		if c.EstimatedMinutes < 0 || c.EstimatedMinutes > maxEstimatedMinutes {
+			return fmt.Errorf("%w: chores[%d].estimated_minutes must be between 0 and %d", errBadTemplate, i, maxEstimatedMinutes)
+		}
+	}
+	return nil
+}
+
+// findTemplate looks for a built in pack first, then the group's templates.
+func findTemplate(ctx context.Context, groupSnap *firestore.DocumentSnapshot, id string) (choreTemplate, error) {
+	for _, p := range starterPacks {
+		if p.ID == id {
+			p.BuiltIn = true
+			return p, nil
+		}
+	}
+	snap, err := groupSnap.Ref.Collection("chore_templates").Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return choreTemplate{}, errTemplateNotFound
+	}
+	if err != nil {
+		return choreTemplate{}, fmt.Errorf("failed to read template %s: %w", id, err)
+	}
+	var t choreTemplate
+	if err := snap.DataTo(&t); err != nil {
+		return choreTemplate{}, fmt.Errorf("failed to read template %s: %w", id, err)
+	}
+	t.ID = snap.Ref.ID
+	return t, nil
+}
+
+// rotation orders the group's members lightest workload first, so handing
+// chores out round robin evens things up. Members away on date are left
+// out.
+func rotation(ctx context.Context, groupSnap *firestore.DocumentSnapshot, date string) ([]string, error) {
+	loads, err := assignmentLoads(ctx, groupSnap)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]memberStats, 0, len(loads))
+	for _, s := range loads {
+		members = append(members, s)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Minutes != members[j].Minutes {
+			return members[i].Minutes < members[j].Minutes
+		}
+		if members[i].Completed != members[j].Completed {
+			return members[i].Completed < members[j].Completed
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	order := make([]string, 0, len(members))
+	for _, s := range members {
+		order = append(order, s.UserID)
+	}
+	return availableOn(ctx, groupSnap, order, date)
+}
+
+// listTemplatesHandler lists the built in packs and, for a group, its
+// saved templates.
+//
+//	GET /templates
+//	GET /groups/{groupId}/templates?user_id=...
+func listTemplatesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	templates := make([]choreTemplate, 0, len(starterPacks))
+	for _, p := range starterPacks {
+		p.BuiltIn = true
+		templates = append(templates, p)
+	}
+
+	groupID := r.PathValue("groupId")
+	if groupID != "" {
+		userID := r.URL.Query().Get("user_id")
+		if userID == "" {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return fmt.Errorf("missing required fields")
+		}
+		groupSnap, err := requireGroupMember(ctx, groupID, userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list templates: %v", err), statusForError(err))
+			return err
+		}
+		docs, err := groupSnap.Ref.Collection("chore_templates").OrderBy("name", firestore.Asc).Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to list templates: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		for _, doc := range docs {
+			var t choreTemplate
+			if err := doc.DataTo(&t); err != nil {
+				continue
+			}
+			t.ID = doc.Ref.ID
+			templates = append(templates, t)
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"templates": templates,
+	})
+	return nil
+}
+
+// saveTemplateHandler saves a template for the group. With from_chores set,
+// the chores are copied from the group's current open chores instead.
+//
+//	POST /groups/{groupId}/templates {"user_id", "name", "description", "chores" or "from_chores": true}
+func saveTemplateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID      string          `json:"user_id"`
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Chores      []templateChore `json:"chores"`
+		FromChores  bool            `json:"from_chores"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save template: %v", err), statusForError(err))
+		return err
+	}
+
+	if req.FromChores {
+		docs, err := groupSnap.Ref.Collection("chores").Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read chores: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		req.Chores = nil
+		for _, doc := range docs {
+			data := doc.Data()
+			if isClosed(data) {
+				continue
+			}
+			c := templateChore{}
+			c.Name, _ = data["chore_name"].(string)
+			c.Details, _ = data["chore_details"].(string)
+			c.Frequency, _ = data["chore_frequency"].(string)
+			minutes, _ := data["estimated_minutes"].(int64)
+			c.EstimatedMinutes = int(minutes)
+			if !validFrequency(c.Frequency) {
+				c.Frequency = "once"
+			}
+			req.Chores = append(req.Chores, c)
+		}
+	}
+
+	t := choreTemplate{Name: req.Name, Description: req.Description, Chores: req.Chores, CreatedBy: req.UserID}
+	if err := t.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	templates := groupSnap.Ref.Collection("chore_templates")
+	existing, err := templates.Select().Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save template: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if len(existing) >= maxGroupTemplates {
+		http.Error(w, fmt.Sprintf("A group can have at most %d templates", maxGroupTemplates), http.StatusConflict)
+		return fmt.Errorf("too many templates")
+	}
+
+	ref, _, err := templates.Add(ctx, map[string]interface{}{
+		"name":        t.Name,
+		"description": t.Description,
+		"chores":      t.Chores,
+		"created_by":  req.UserID,
+		"created_at":  firestore.ServerTimestamp,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save template: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":     "Template saved",
+		"template_id": ref.ID,
+		"chores":      len(t.Chores),
+	})
+	return nil
+}
+
+// deleteTemplateHandler removes a saved template. Whoever saved it and the
+// group owner can.
+//
+//	DELETE /groups/{groupId}/templates/{templateId}?user_id=...
+func deleteTemplateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, templateID := r.PathValue("groupId"), r.PathValue("templateId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete template: %v", err), statusForError(err))
+		return err
+	}
+	ref := groupSnap.Ref.Collection("chore_templates").Doc(templateID)
+	snap, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		http.Error(w, fmt.Sprintf("Failed to delete template: %v", errTemplateNotFound), http.StatusNotFound)
+		return errTemplateNotFound
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete template: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	owner, _ := groupSnap.Data()["created_by"].(string)
+	if by, _ := snap.Data()["created_by"].(string); by != userID && owner != userID {
+		http.Error(w, "Only whoever saved the template or the group owner can delete it", http.StatusForbidden)
+		return fmt.Errorf("not allowed to delete template")
+	}
+
+	if _, err := ref.Delete(ctx); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete template: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	writeJSON(w, map[string]string{
+		"message":     fmt.Sprintf("Template %s deleted", templateID),
+		"template_id": templateID,
+	})
+	return nil
+}
+
+// applyTemplateHandler adds every chore in a pack or saved template to the
+// group, in one transaction.
+//
+//	POST /groups/{groupId}/templates/{templateId}/apply {"user_id", "start_date", "rotate"}
+func applyTemplateHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID    string `json:"user_id"`
+		StartDate string `json:"start_date"` // YYYY-MM-DD, default today
+		Rotate    *bool  `json:"rotate"`     // default true; false leaves the chores up for grabs
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, templateID := r.PathValue("groupId"), r.PathValue("templateId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply template: %v", err), statusForError(err))
+		return err
+	}
+	start := groupToday(groupSnap)
+	if req.StartDate != "" {
+		if _, err := time.Parse(dateLayout, req.StartDate); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid start_date %q; use YYYY-MM-DD", req.StartDate), http.StatusBadRequest)
+			return err
+		}
+		start = req.StartDate
+	}
+
+	t, err := findTemplate(ctx, groupSnap, templateID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, errTemplateNotFound) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, fmt.Sprintf("Failed to apply template: %v", err), code)
+		return err
+	}
+
+	var order []string
+	if req.Rotate == nil || *req.Rotate {
+		order, err = rotation(ctx, groupSnap, start)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to apply template: %v", err), http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	chores := groupSnap.Ref.Collection("chores")
+	created := make([]map[string]interface{}, 0, len(t.Chores))
+	var activity []map[string]interface{}
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		created = created[:0]
+		activity = activity[:0]
+		for i, c := range t.Chores {
+			assignees := []string{}
+			if len(order) > 0 {
+				assignees = []string{order[i%len(order)]}
+			}
+			primary := ""
+			if len(assignees) > 0 {
+				primary = assignees[0]
+			}
+			ref := chores.NewDoc()
+			err := tx.Create(ref, map[string]interface{}{
+				"created_at":           firestore.ServerTimestamp,
+				"group_id":             groupID,
+				"chore_name":           c.Name,
+				"chore_details":        c.Details,
+				"chore_due_date":       start,
+				"chore_frequency":      c.Frequency,
+				"chore_assignee":       primary,
+				"assignees":            assignees,
+				"completion_policy":    policyAny,
+				"completed_by_members": []string{},
+				"estimated_minutes":    c.EstimatedMinutes,
+				"created_by":           req.UserID,
+				"chore_status":         "not started",
+				"completed_at":         "NA",
+				"template_id":          t.ID,
+			})
+			if err != nil {
+				return err
+			}
+			created = append(created, map[string]interface{}{
+				"chore_id":       ref.ID,
+				"chore_name":     c.Name,
+				"chore_assignee": primary,
+			})
+			activity = append(activity, map[string]interface{}{
+				"chore_id":   ref.ID,
+				"chore_name": c.Name,
+				"assignees":  assignees,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply template: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	recordActivities(ctx, groupSnap.Ref, "chore_created", req.UserID, activity)
+
+	writeJSON(w, map[string]interface{}{
+		"message":     fmt.Sprintf("Added %d chores from %s", len(created), t.Name),
+		"template_id": t.ID,
+		"chores":      created,
+	})
+	return nil
+}
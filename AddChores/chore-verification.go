@@ -0,0 +1,265 @@
+package chores
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Verification: "done" means someone else checked it.
+
+	A group turns it on for every chore with settings.require_verification,
+	and a chore can override that either way with requires_verification.
+	Completing such a chore doesn't close it. It goes to chore_status
+	"pending verification" with what was submitted kept on the chore,
+
+	pending_completion: {by, due_date, on_time, notes, attachment_ids, points, base_points, days_late, submitted_at}
+
+	and a "submitted" history event. Another member then approves it, which
+	completes it as if the submitter had just done it (streaks and stats
+	count it then), or rejects it with a comment, which reopens it. For an
+	"all" chore each part is recorded as it is done and the chore is
+	verified once the last part is in.
+*/
+
+const statusPendingVerification = "pending verification"
+
+var (
+	errPendingVerification    = errors.New("chore is waiting for verification")
+	errNotPendingVerification = errors.New("chore is not waiting for verification")
+	errOwnChore               = errors.New("you can't verify a chore you did")
+)
+
+func isPendingVerification(chore map[string]interface{}) bool {
+	s, _ := chore["chore_status"].(string)
+	return s == statusPendingVerification
+}
+
+// needsVerification is the chore's requires_verification, or the group's
+// settings.require_verification when the chore doesn't say.
+func needsVerification(groupSnap *firestore.DocumentSnapshot, chore map[string]interface{}) bool {
+	if v, ok := chore["requires_verification"].(bool); ok {
+		return v
+	}
+	v, err := groupSnap.DataAt("settings.require_verification")
+	if err != nil {
+		return false
+	}
+	required, _ := v.(bool)
+	return required
+}
+
+// submitForVerification is the change completing makes instead of closing
+// the chore when it needs verifying.
+//
+// The points are worked out now, so reviewing late doesn't cost the
+// submitter.
+func submitForVerification(chore map[string]interface{}, req choreActionRequest, done []string, onTime bool, points int64, base int64, daysLate int64) ([]firestore.Update, map[string]interface{}, error) {
+	due, _ := chore["chore_due_date"].(string)
+	submission := withAttachments(map[string]interface{}{
+		"by":           req.UserID,
+		"due_date":     due,
+		"on_time":      onTime,
+		"notes":        req.Notes,
+		"points":       points,
+		"base_points":  base,
+		"days_late":    daysLate,
+		"submitted_at": firestore.ServerTimestamp,
+	}, req.AttachmentIDs)
+	updates := []firestore.Update{
+		{Path: "chore_status", Value: statusPendingVerification},
+		{Path: "pending_completion", Value: submission},
+	}
+	if done != nil {
+		updates = append(updates, firestore.Update{Path: "completed_by_members", Value: done})
+	}
+	return updates, withAttachments(map[string]interface{}{
+		"action":   "submitted",
+		"due_date": due,
+		"on_time":  onTime,
+	}, req.AttachmentIDs), nil
+}
+
+// notifyVerifiers lets everyone but the submitter know there's a chore to
+// check.
+func notifyVerifiers(ctx context.Context, groupSnap *firestore.DocumentSnapshot, choreID string, submitter string) {
+	members, err := groupMembers(ctx, groupSnap)
+	if err != nil {
+		log.Printf("Failed to read members to notify about chore %s: %v", choreID, err)
+		return
+	}
+	name := choreName(ctx, groupSnap, choreID)
+	for uid := range members {
+		if uid == submitter {
+			continue
+		}
+		queueNotification(ctx, groupSnap, notification{
+			Type:  "verification_requested",
+			To:    uid,
+			Title: "Chore needs checking",
+			Body:  fmt.Sprintf("%q was marked done. Can you check it?", name),
+		})
+	}
+}
+
+// verifyChoreHandler approves a chore waiting for verification.
+//
+//	POST /groups/{groupId}/chores/{choreId}/verify {"user_id", "notes"}
+func verifyChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return reviewChore(ctx, w, r, true)
+}
+
+// rejectChoreHandler sends a chore waiting for verification back to be
+// done again. notes, the reason, is required.
+//
+//	POST /groups/{groupId}/chores/{choreId}/reject {"user_id", "notes"}
+func rejectChoreHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return reviewChore(ctx, w, r, false)
+}
+
+func reviewChore(ctx context.Context, w http.ResponseWriter, r *http.Request, approve bool) error {
+	req, groupSnap, err := readChoreAction(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	if !approve && req.Notes == "" {
+		http.Error(w, "notes is required to reject a chore; say what still needs doing", http.StatusBadRequest)
+		return fmt.Errorf("missing rejection notes")
+	}
+	choreID := r.PathValue("choreId")
+	choreRef := groupSnap.Ref.Collection("chores").Doc(choreID)
+	today := groupToday(groupSnap)
+
+	var submitter string
+	var onTime bool
+	var streak, minutes int64
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(choreRef)
+		if status.Code(err) == codes.NotFound {
+			return errChoreNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read chore %s: %w", choreID, err)
+		}
+		chore := snap.Data()
+		if !isPendingVerification(chore) {
+			return errNotPendingVerification
+		}
+		submission, _ := chore["pending_completion"].(map[string]interface{})
+		submitter, _ = submission["by"].(string)
+		done := stringList(chore["completed_by_members"])
+		if req.UserID == submitter || containsString(done, req.UserID) {
+			return errOwnChore
+		}
+		due, _ := submission["due_date"].(string)
+		onTime, _ = submission["on_time"].(bool)
+		attachmentIDs := stringList(submission["attachment_ids"])
+
+		var updates []firestore.Update
+		var event map[string]interface{}
+		by, action, notes := req.UserID, "rejected", req.Notes
+		if approve {
+			streak, _ = chore["streak_count"].(int64)
+			minutes = choreMinutes(chore)
+			streakBefore := streak
+			if onTime {
+				streak++
+			} else {
+				streak = 0
+			}
+			updates = append(closeOrAdvance(chore, today, "completed"),
+				firestore.Update{Path: "last_completed_at", Value: firestore.ServerTimestamp},
+				firestore.Update{Path: "completed_by", Value: submitter},
+				firestore.Update{Path: "streak_count", Value: streak},
+				firestore.Update{Path: "verified_by", Value: req.UserID},
+			)
+			// Recorded as the submitter's completion so stats credit them
+			by, action = submitter, "completed"
+			notes, _ = submission["notes"].(string)
+			event = withAttachments(map[string]interface{}{
+				"due_date":           due,
+				"on_time":            onTime,
+				"verified_by":        req.UserID,
+				"verification_notes": req.Notes,
+				"streak_before":      streakBefore,
+				"points":             submission["points"],
+				"base_points":        submission["base_points"],
+				"days_late":          submission["days_late"],
+			}, attachmentIDs)
+		} else {
+			updates = []firestore.Update{
+				{Path: "chore_status", Value: "not started"},
+				{Path: "last_rejection", Value: map[string]interface{}{
+					"by":    req.UserID,
+					"notes": req.Notes,
+					"at":    firestore.ServerTimestamp,
+				}},
+			}
+			if completionPolicy(chore) == policyAll {
+				// Only the last part has to be done again
+				updates = append(updates, firestore.Update{Path: "completed_by_members", Value: remainingAssignees(done, []string{submitter})})
+			}
+			event = map[string]interface{}{
+				"due_date":     due,
+				"submitted_by": submitter,
+			}
+		}
+		updates = append(updates,
+			firestore.Update{Path: "pending_completion", Value: firestore.Delete},
+			firestore.Update{Path: "updated_at", Value: firestore.ServerTimestamp},
+		)
+		if err := tx.Update(choreRef, updates); err != nil {
+			return fmt.Errorf("failed to update chore %s: %w", choreID, err)
+		}
+		historyRef := choreRef.Collection("history").NewDoc()
+		if err := tx.Create(historyRef, historyDoc(snap, by, action, notes, event)); err != nil {
+			return err
+		}
+		if err := recordChoreActivity(tx, snap, historyRef, by, action, event); err != nil {
+			return err
+		}
+		return creditPoints(tx, snap, historyRef, by, action, event)
+	})
+	if err != nil {
+		return writeChangeError(w, err)
+	}
+
+	name := choreName(ctx, groupSnap, choreID)
+	if approve {
+		workOffMakeUp(ctx, groupSnap, submitter, minutes)
+		queueNotification(ctx, groupSnap, notification{
+			Type:  "chore_verified",
+			To:    submitter,
+			Title: "Chore verified",
+			Body:  fmt.Sprintf("%q was checked and counts as done.", name),
+		})
+		writeJSON(w, map[string]interface{}{
+			"message":      fmt.Sprintf("Chore %s verified", choreID),
+			"chore_id":     choreID,
+			"completed_by": submitter,
+			"on_time":      onTime,
+			"streak_count": streak,
+		})
+		return nil
+	}
+	queueNotification(ctx, groupSnap, notification{
+		Type:  "chore_rejected",
+		To:    submitter,
+		Title: "Chore sent back",
+		Body:  fmt.Sprintf("%q needs another go: %s", name, req.Notes),
+	})
+	writeJSON(w, map[string]interface{}{
+		"message":      fmt.Sprintf("Chore %s sent back", choreID),
+		"chore_id":     choreID,
+		"submitted_by": submitter,
+		"chore_status": "not started",
+	})
+	return nil
+}
@@ -0,0 +1,303 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	_ "time/tzdata" // groups can pick any IANA timezone
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	ChoreHandler serves everything that happens to a chore after it is
+	created (AddChoreHandler still creates them). Routes are matched by
+	method and path like the Group function; see README.md for the list.
+
+	Chore docs live in groups/{groupId}/chores/{choreId} and keep the
+	fields AddChoreHandler writes (chore_name, chore_due_date as YYYY-MM-DD,
+	chore_frequency, chore_assignee, chore_status, ...).
+*/
+
+const dateLayout = "2006-01-02"
+
+var (
+	errGroupNotFound  = errors.New("group not found")
+	errNotGroupMember = errors.New("user is not a member of this group")
+	errGroupDeleted   = errors.New("group has been deleted")
+	errChoreNotFound  = errors.New("chore not found")
+)
+
+// handler adapts a chore handler to the mux. They write their own error
+// responses, so the returned error is only logged.
+func handler(h func(context.Context, http.ResponseWriter, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(r.Context(), w, r); err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+}
+
+// newRouter maps every chore endpoint by method and path.
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// History
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/complete", handler(completeChoreHandler))
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/skip", handler(skipChoreHandler))
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/reassign", handler(reassignChoreHandler))
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/snooze", handler(snoozeChoreHandler))
+	mux.HandleFunc("GET /groups/{groupId}/chores/{choreId}/history", handler(choreHistoryHandler))
+	mux.HandleFunc("GET /groups/{groupId}/history", handler(groupHistoryHandler))
+
+	// Verification
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/verify", handler(verifyChoreHandler))
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/reject", handler(rejectChoreHandler))
+
+	// Up for grabs
+	mux.HandleFunc("GET /groups/{groupId}/chores/open", handler(openChoresHandler))
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/claim", handler(claimChoreHandler))
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/unclaim", handler(unclaimChoreHandler))
+	mux.HandleFunc("POST /jobs/auto-assign", handler(autoAssignChoresHandler))
+
+	// Editing and tags
+	mux.HandleFunc("PATCH /groups/{groupId}/chores/{choreId}", handler(updateChoreHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/chores/{choreId}", handler(deleteChoreHandler))
+	mux.HandleFunc("GET /groups/{groupId}/tags", handler(listTagsHandler))
+	mux.HandleFunc("POST /groups/{groupId}/tags", handler(addTagHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/tags/{tag}", handler(deleteTagHandler))
+
+	// Assignees
+	mux.HandleFunc("GET /chores/mine", handler(myChoresHandler))
+	mux.HandleFunc("GET /groups/{groupId}/chores/mine", handler(myChoresHandler))
+
+	// Swaps
+	mux.HandleFunc("POST /groups/{groupId}/swaps", handler(proposeSwapHandler))
+	mux.HandleFunc("GET /groups/{groupId}/swaps", handler(listSwapsHandler))
+	mux.HandleFunc("POST /groups/{groupId}/swaps/{swapId}/accept", handler(acceptSwapHandler))
+	mux.HandleFunc("POST /groups/{groupId}/swaps/{swapId}/decline", handler(declineSwapHandler))
+	mux.HandleFunc("POST /groups/{groupId}/swaps/{swapId}/cancel", handler(cancelSwapHandler))
+
+	// Templates
+	mux.HandleFunc("GET /templates", handler(listTemplatesHandler))
+	mux.HandleFunc("GET /groups/{groupId}/templates", handler(listTemplatesHandler))
+	mux.HandleFunc("POST /groups/{groupId}/templates", handler(saveTemplateHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/templates/{templateId}", handler(deleteTemplateHandler))
+	mux.HandleFunc("POST /groups/{groupId}/templates/{templateId}/apply", handler(applyTemplateHandler))
+
+	// Import / export
+	mux.HandleFunc("POST /groups/{groupId}/chores/import", handler(importChoresHandler))
+	mux.HandleFunc("GET /groups/{groupId}/export", handler(exportHandler))
+
+	// Calendar feeds
+	mux.HandleFunc("POST /groups/{groupId}/calendar", handler(createFeedHandler))
+	mux.HandleFunc("GET /groups/{groupId}/calendar", handler(listFeedsHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/calendar/{token}", handler(revokeFeedHandler))
+	mux.HandleFunc("GET /calendar/{token}", handler(calendarFeedHandler))
+
+	// Attachments
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/attachments", handler(createAttachmentHandler))
+	mux.HandleFunc("GET /groups/{groupId}/chores/{choreId}/attachments", handler(listAttachmentsHandler))
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/attachments/{attachmentId}/confirm", handler(confirmAttachmentHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/chores/{choreId}/attachments/{attachmentId}", handler(deleteAttachmentHandler))
+	mux.HandleFunc("PUT /attachments/local/{object...}", handler(localObjectHandler))
+	mux.HandleFunc("GET /attachments/local/{object...}", handler(localObjectHandler))
+
+	// Points
+	mux.HandleFunc("POST /groups/{groupId}/chores/{choreId}/undo", handler(undoCompletionHandler))
+	mux.HandleFunc("GET /groups/{groupId}/leaderboard", handler(leaderboardHandler))
+	mux.HandleFunc("GET /groups/{groupId}/points", handler(pointsLedgerHandler))
+
+	// Away mode
+	mux.HandleFunc("GET /groups/{groupId}/away", handler(listAwayHandler))
+	mux.HandleFunc("POST /groups/{groupId}/members/{memberId}/away", handler(addAwayHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/members/{memberId}/away/{awayId}", handler(deleteAwayHandler))
+	mux.HandleFunc("POST /jobs/away-redistribute", handler(redistributeAwayJobHandler))
+
+	// Stats
+	mux.HandleFunc("GET /groups/{groupId}/stats", handler(choreStatsHandler))
+
+	return mux
+}
+
+var router = newRouter()
+
+// ChoreHandler is the function entry point for everything but creating chores.
+func ChoreHandler(w http.ResponseWriter, r *http.Request) {
+	router.ServeHTTP(w, r)
+}
+
+// requireGroupMember loads the group and checks that uid is the owner or
+// has a doc in its members subcollection. Groups that have been deleted
+// (deleted_at set by the Group function) are gone for everyone.
+func requireGroupMember(ctx context.Context, groupID string, uid string) (*firestore.DocumentSnapshot, error) {
+	groupRef := firestoreClient.Collection("groups").Doc(groupID)
+	snap, err := groupRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group %s: %w", groupID, err)
+	}
+	if _, deleted := snap.Data()["deleted_at"]; deleted {
+		return nil, errGroupDeleted
+	}
+	if owner, _ := snap.Data()["created_by"].(string); owner != "" && owner == uid {
+		return snap, nil
+	}
+	if _, err := groupRef.Collection("members").Doc(uid).Get(ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, errNotGroupMember
+		}
+		return nil, fmt.Errorf("failed to read membership for %s: %w", uid, err)
+	}
+	return snap, nil
+}
+
+// isGroupMember reports whether uid belongs to the group, for checking
+// assignees rather than callers.
+func isGroupMember(ctx context.Context, groupSnap *firestore.DocumentSnapshot, uid string) (bool, error) {
+	if owner, _ := groupSnap.Data()["created_by"].(string); owner == uid {
+		return true, nil
+	}
+	_, err := groupSnap.Ref.Collection("members").Doc(uid).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// memberGroups reads the given groups and uid's membership in each in one
+// batch, for queries that span groups. It returns the IDs of the groups uid
+// may still see: ones that exist, aren't deleted and uid owns or is a
+// member of.
+func memberGroups(ctx context.Context, groupRefs []*firestore.DocumentRef, uid string) (map[string]bool, error) {
+	visible := make(map[string]bool, len(groupRefs))
+	if len(groupRefs) == 0 {
+		return visible, nil
+	}
+	refs := make([]*firestore.DocumentRef, 0, 2*len(groupRefs))
+	for _, ref := range groupRefs {
+		refs = append(refs, ref, ref.Collection("members").Doc(uid))
+	}
+	snaps, err := firestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read groups: %w", err)
+	}
+	for i := 0; i < len(snaps); i += 2 {
+		group, member := snaps[i], snaps[i+1]
+		if !group.Exists() {
+			continue
+		}
+		if _, deleted := group.Data()["deleted_at"]; deleted {
+			continue
+		}
+		owner, _ := group.Data()["created_by"].(string)
+		if owner == uid || member.Exists() {
+			visible[group.Ref.ID] = true
+		}
+	}
+	return visible, nil
+}
+
+// statusForError maps the shared chore errors onto HTTP status codes.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, errGroupDeleted):
+		return http.StatusGone
+	case errors.Is(err, errGroupNotFound), errors.Is(err, errChoreNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotGroupMember):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// groupLocation is the group's settings.timezone, or UTC.
+func groupLocation(groupSnap *firestore.DocumentSnapshot) *time.Location {
+	if tz, err := groupSnap.DataAt("settings.timezone"); err == nil {
+		if name, _ := tz.(string); name != "" {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc
+			}
+		}
+	}
+	return time.UTC
+}
+
+// groupToday is today's date in the group's timezone.
+func groupToday(groupSnap *firestore.DocumentSnapshot) string {
+	return time.Now().In(groupLocation(groupSnap)).Format(dateLayout)
+}
+
+// nextDueDate moves a recurring chore's due date forward by its frequency
+// until it is after today. One-off chores return "".
+//
+// Monthly chores land on day, the day of the month they were first
+// scheduled for, or the last day of shorter months. A chore first due on
+// Jan 31 is due Feb 28 (or 29) and then Mar 31, rather than drifting to
+// the 3rd. day 0 uses due's own day.
+func nextDueDate(due string, frequency string, today string, day int) string {
+	d, err := time.Parse(dateLayout, due)
+	if err != nil {
+		d, _ = time.Parse(dateLayout, today)
+	}
+	if day < 1 || day > 31 {
+		day = d.Day()
+	}
+	for n := 1; ; n++ {
+		var next time.Time
+		switch frequency {
+		case "daily":
+			next = d.AddDate(0, 0, n)
+		case "weekly":
+			next = d.AddDate(0, 0, 7*n)
+		case "monthly":
+			// Day 1 of the month normalizes, so only the day needs clamping
+			first := time.Date(d.Year(), d.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+			last := first.AddDate(0, 1, -1).Day()
+			next = first.AddDate(0, 0, min(day, last)-1)
+		default:
+			return ""
+		}
+		if s := next.Format(dateLayout); s > today {
+			return s
+		}
+	}
+}
+
+// dateRange reads from / to (YYYY-MM-DD, inclusive) as instants in loc.
+// Either may be empty.
+func dateRange(from string, to string, loc *time.Location) (time.Time, time.Time, error) {
+	var start, end time.Time
+	if from != "" {
+		t, err := time.ParseInLocation(dateLayout, from, loc)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid from %q; use YYYY-MM-DD", from)
+		}
+		start = t
+	}
+	if to != "" {
+		t, err := time.ParseInLocation(dateLayout, to, loc)
+		if err != nil {
+			return start, end, fmt.Errorf("invalid to %q; use YYYY-MM-DD", to)
+		}
+		end = t.AddDate(0, 0, 1)
+	}
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return start, end, fmt.Errorf("from must not be after to")
+	}
+	return start, end, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
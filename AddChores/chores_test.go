@@ -0,0 +1,63 @@
+package chores
+
+import "testing"
+
+func TestNextDueDate(t *testing.T) {
+	tests := []struct {
+		name      string
+		due       string
+		frequency string
+		today     string
+		day       int
+		want      string
+	}{
+		{"monthly 31st into February", "2026-01-31", "monthly", "2026-01-31", 0, "2026-02-28"},
+		{"monthly 31st into leap February", "2028-01-31", "monthly", "2028-01-31", 0, "2028-02-29"},
+		{"monthly back to the 31st after February", "2026-02-28", "monthly", "2026-02-28", 31, "2026-03-31"},
+		{"monthly 31st into April", "2026-03-31", "monthly", "2026-03-31", 31, "2026-04-30"},
+		{"monthly 30th after February", "2026-02-28", "monthly", "2026-02-28", 30, "2026-03-30"},
+		{"monthly Feb 29 into March", "2028-02-29", "monthly", "2028-02-29", 0, "2028-03-29"},
+		{"monthly catches up past today", "2026-01-31", "monthly", "2026-05-01", 31, "2026-05-31"},
+		{"monthly across the year", "2026-12-31", "monthly", "2026-12-31", 0, "2027-01-31"},
+		{"monthly 29th into leap February", "2028-01-29", "monthly", "2028-01-29", 29, "2028-02-29"},
+		{"weekly keeps the weekday", "2026-10-05", "weekly", "2026-10-05", 0, "2026-10-12"},
+		{"weekly catches up past today", "2026-10-05", "weekly", "2026-10-19", 0, "2026-10-26"},
+		{"weekly across February in a leap year", "2028-02-26", "weekly", "2028-02-26", 0, "2028-03-04"},
+		{"daily", "2026-10-19", "daily", "2026-10-19", 0, "2026-10-20"},
+		{"one-off", "2026-10-19", "once", "2026-10-19", 0, ""},
+		{"no due date steps from today", "", "weekly", "2026-10-19", 0, "2026-10-26"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextDueDate(tt.due, tt.frequency, tt.today, tt.day); got != tt.want {
+				t.Errorf("nextDueDate(%q, %q, %q, %d) = %q, want %q", tt.due, tt.frequency, tt.today, tt.day, got, tt.want)
+			}
+		})
+	}
+}
+
+// Advancing a monthly chore again and again comes back to the day it was
+// first scheduled for after every short month.
+func TestCloseOrAdvanceMonthlyKeepsDay(t *testing.T) {
+	chore := map[string]interface{}{
+		"chore_frequency": "monthly",
+		"chore_due_date":  "2026-01-31",
+	}
+	for _, want := range []string{"2026-02-28", "2026-03-31", "2026-04-30", "2026-05-31"} {
+		today := scheduleAnchor(chore)
+		for _, u := range closeOrAdvance(chore, today, "completed") {
+			switch v := u.Value.(type) {
+			case int:
+				chore[u.Path] = int64(v) // as Firestore reads it back
+			case string:
+				chore[u.Path] = v
+			}
+		}
+		if got := chore["chore_due_date"]; got != want {
+			t.Fatalf("chore_due_date = %v, want %s", got, want)
+		}
+		if got := chore["schedule_day"]; got != int64(31) {
+			t.Fatalf("schedule_day = %v, want 31", got)
+		}
+	}
+}
@@ -0,0 +1,611 @@
+package chores
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Away mode: members say when they're travelling so chores stop landing
+	on them. Ranges live next to the membership, not in it, so going away
+	never creates a members doc (the owner has none) or changes the stats
+	counted from that collection:
+
+	/groups/{groupId}/away/{userId}
+		away: [{id, start, end, note, make_up}]   (YYYY-MM-DD, inclusive)
+		away_until                                (latest end, for queries)
+		make_up_minutes                           (effort still owed)
+
+	Rotations, auto-assignment and redistribution skip anyone away on the
+	date in question. Declaring a range moves the member's open chores due
+	inside it to whoever is lightest, and a daily job does the same for
+	recurring chores that roll into a range later. With make_up, the effort
+	of every moved chore is added to make_up_minutes; until it is worked
+	off by completing chores, assignment treats the member as that much
+	lighter, so more comes their way once they're back.
+*/
+
+const (
+	maxAwayRanges     = 10
+	maxAwayDays       = 90
+	awayLookaheadDays = 7
+	awayJobBatchSize  = 200
+	awayJobTimeBudget = 45 * time.Second
+)
+
+var (
+	errAwayOverlap = errors.New("overlaps another away range")
+	errAwayStale   = errors.New("chore is no longer assigned to the member")
+)
+
+// awayRef is the doc holding uid's away ranges and make up minutes.
+func awayRef(groupRef *firestore.DocumentRef, uid string) *firestore.DocumentRef {
+	return groupRef.Collection("away").Doc(uid)
+}
+
+// awayRange is one stretch a member is away.
+type awayRange struct {
+	ID     string `json:"id" firestore:"id"`
+	Start  string `json:"start" firestore:"start"`
+	End    string `json:"end" firestore:"end"`
+	Note   string `json:"note,omitempty" firestore:"note"`
+	MakeUp bool   `json:"make_up" firestore:"make_up"`
+}
+
+func (a awayRange) covers(date string) bool {
+	return date != "" && a.Start <= date && date <= a.End
+}
+
+// awayRanges reads the away list off a member's away doc.
+func awayRanges(member map[string]interface{}) []awayRange {
+	raw, _ := member["away"].([]interface{})
+	ranges := make([]awayRange, 0, len(raw))
+	for _, v := range raw {
+		m, _ := v.(map[string]interface{})
+		var a awayRange
+		a.ID, _ = m["id"].(string)
+		a.Start, _ = m["start"].(string)
+		a.End, _ = m["end"].(string)
+		a.Note, _ = m["note"].(string)
+		a.MakeUp, _ = m["make_up"].(bool)
+		ranges = append(ranges, a)
+	}
+	return ranges
+}
+
+// awayUntil is the latest end of ranges, or "" when there are none.
+func awayUntil(ranges []awayRange) string {
+	until := ""
+	for _, a := range ranges {
+		if a.End > until {
+			until = a.End
+		}
+	}
+	return until
+}
+
+// awayOn returns the members away on date.
+func awayOn(ctx context.Context, groupSnap *firestore.DocumentSnapshot, date string) (map[string]awayRange, error) {
+	docs, err := groupSnap.Ref.Collection("away").Where("away_until", ">=", date).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read away members: %w", err)
+	}
+	away := make(map[string]awayRange)
+	for _, doc := range docs {
+		for _, a := range awayRanges(doc.Data()) {
+			if a.covers(date) {
+				away[doc.Ref.ID] = a
+			}
+		}
+	}
+	return away, nil
+}
+
+// availableOn drops whoever is away on date from ids. If that would leave
+// nobody, ids come back as they were: someone has to do the chore.
+func availableOn(ctx context.Context, groupSnap *firestore.DocumentSnapshot, ids []string, date string) ([]string, error) {
+	away, err := awayOn(ctx, groupSnap, date)
+	if err != nil {
+		return nil, err
+	}
+	available := make([]string, 0, len(ids))
+	for _, uid := range ids {
+		if _, ok := away[uid]; !ok {
+			available = append(available, uid)
+		}
+	}
+	if len(available) == 0 {
+		return ids, nil
+	}
+	return available, nil
+}
+
+// owedMinutes is each member's make_up_minutes still to work off.
+func owedMinutes(ctx context.Context, groupSnap *firestore.DocumentSnapshot) (map[string]int64, error) {
+	docs, err := groupSnap.Ref.Collection("away").Where("make_up_minutes", ">", 0).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read make up minutes: %w", err)
+	}
+	owed := make(map[string]int64, len(docs))
+	for _, doc := range docs {
+		owed[doc.Ref.ID], _ = doc.Data()["make_up_minutes"].(int64)
+	}
+	return owed, nil
+}
+
+// workOffMakeUp takes minutes of completed work off what uid owes. It runs
+// after the completion is saved and only logs failures.
+func workOffMakeUp(ctx context.Context, groupSnap *firestore.DocumentSnapshot, uid string, minutes int64) {
+	if uid == "" || minutes <= 0 {
+		return
+	}
+	ref := awayRef(groupSnap.Ref, uid)
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		owed, _ := snap.Data()["make_up_minutes"].(int64)
+		if owed <= 0 {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "make_up_minutes", Value: max(owed-minutes, 0)},
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to update make up minutes for %s in group %s: %v", uid, groupSnap.Ref.ID, err)
+	}
+}
+
+// choreMinutes is the chore's estimated_minutes, or the default effort.
+func choreMinutes(chore map[string]interface{}) int64 {
+	if m, _ := chore["estimated_minutes"].(int64); m > 0 {
+		return m
+	}
+	return defaultEffortMinutes
+}
+
+// redistributeAway moves uid's open chores due inside a off them. Chores
+// shared with others ("all" chores) just drop uid; the rest go to whoever
+// is lightest and not away on the due date. It returns the chores moved and
+// the ones nobody could take.
+func redistributeAway(ctx context.Context, groupSnap *firestore.DocumentSnapshot, uid string, a awayRange, by string) ([]string, []string, error) {
+	col := groupSnap.Ref.Collection("chores")
+	docs, err := col.Where("assignees", "array-contains", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read chores: %w", err)
+	}
+	legacy, err := col.Where("chore_assignee", "==", uid).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read chores: %w", err)
+	}
+	members, err := groupMembers(ctx, groupSnap)
+	if err != nil {
+		return nil, nil, err
+	}
+	loads, err := assignmentLoads(ctx, groupSnap)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	moved, stuck := []string{}, []string{}
+	seen := make(map[string]bool)
+	var owed int64
+	for _, doc := range append(docs, legacy...) {
+		data := doc.Data()
+		due, _ := data["chore_due_date"].(string)
+		if seen[doc.Ref.ID] || isClosed(data) || isPendingVerification(data) || !a.covers(due) {
+			continue
+		}
+		seen[doc.Ref.ID] = true
+
+		assignees := choreAssignees(data)
+		var to []string
+		if completionPolicy(data) == policyAll && len(assignees) > 1 {
+			to = remainingAssignees(assignees, []string{uid})
+		} else {
+			away, err := awayOn(ctx, groupSnap, due)
+			if err != nil {
+				return moved, stuck, err
+			}
+			candidates := make([]string, 0, len(members))
+			for m := range members {
+				if _, isAway := away[m]; !isAway && m != uid && !containsString(assignees, m) {
+					candidates = append(candidates, m)
+				}
+			}
+			pick := lightest(loads, candidates)
+			if pick == "" {
+				stuck = append(stuck, doc.Ref.ID)
+				continue
+			}
+			to = remainingAssignees(assignees, []string{uid})
+			to = append([]string{pick}, to...)
+			s := loads[pick]
+			s.Minutes += choreMinutes(data)
+			loads[pick] = s
+		}
+
+		err = changeChore(ctx, groupSnap.Ref.ID, doc.Ref.ID, by, "reassigned", "away", func(chore map[string]interface{}) ([]firestore.Update, map[string]interface{}, error) {
+			if !containsString(choreAssignees(chore), uid) {
+				return nil, nil, errAwayStale
+			}
+			from, _ := chore["chore_assignee"].(string)
+			toAssignee := ""
+			if len(to) > 0 {
+				toAssignee = to[0]
+			}
+			return assigneeUpdates(chore, to), map[string]interface{}{
+				"from_assignee":  from,
+				"to_assignee":    toAssignee,
+				"from_assignees": choreAssignees(chore),
+				"to_assignees":   to,
+				"reason":         "away",
+			}, nil
+		})
+		if errors.Is(err, errAwayStale) {
+			continue // reassigned since the query ran
+		}
+		if err != nil {
+			return moved, stuck, err
+		}
+		moved = append(moved, doc.Ref.ID)
+		owed += choreMinutes(data)
+	}
+
+	if a.MakeUp && owed > 0 {
+		_, err := awayRef(groupSnap.Ref, uid).Set(ctx, map[string]interface{}{
+			"make_up_minutes": firestore.Increment(owed),
+		}, firestore.MergeAll)
+		if err != nil {
+			return moved, stuck, fmt.Errorf("failed to record make up minutes: %w", err)
+		}
+	}
+	return moved, stuck, nil
+}
+
+// canManageAway is whether caller may change member's away ranges: their
+// own, or anyone's for the owner.
+func canManageAway(groupSnap *firestore.DocumentSnapshot, caller string, member string) bool {
+	owner, _ := groupSnap.Data()["created_by"].(string)
+	return caller == member || caller == owner
+}
+
+// addAwayHandler declares a range a member is away and moves their chores
+// in it to others.
+//
+//	POST /groups/{groupId}/members/{memberId}/away {"user_id", "start", "end", "note", "make_up"}
+func addAwayHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		Start  string `json:"start"` // YYYY-MM-DD
+		End    string `json:"end"`   // YYYY-MM-DD, inclusive
+		Note   string `json:"note"`
+		MakeUp bool   `json:"make_up"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" || req.Start == "" || req.End == "" {
+		http.Error(w, "user_id, start and end are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	start, err1 := time.Parse(dateLayout, req.Start)
+	end, err2 := time.Parse(dateLayout, req.End)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "start and end must be YYYY-MM-DD", http.StatusBadRequest)
+		return fmt.Errorf("invalid away dates")
+	}
+	if end.Before(start) || end.Sub(start) > maxAwayDays*24*time.Hour {
+		http.Error(w, fmt.Sprintf("end must be on or after start and at most %d days later", maxAwayDays), http.StatusBadRequest)
+		return fmt.Errorf("invalid away range")
+	}
+	if len(req.Note) > maxNotesLength {
+		http.Error(w, fmt.Sprintf("note can be at most %d characters", maxNotesLength), http.StatusBadRequest)
+		return fmt.Errorf("note too long")
+	}
+	groupID, memberID := r.PathValue("groupId"), r.PathValue("memberId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to set away: %v", err), statusForError(err))
+		return err
+	}
+	if !canManageAway(groupSnap, req.UserID, memberID) {
+		http.Error(w, "Only the member or the group owner can set someone away", http.StatusForbidden)
+		return fmt.Errorf("not allowed to set %s away", memberID)
+	}
+	if ok, err := isGroupMember(ctx, groupSnap, memberID); err != nil || !ok {
+		if err == nil {
+			err = errNotGroupMember
+		}
+		http.Error(w, fmt.Sprintf("Failed to set away: %v", err), statusForError(err))
+		return err
+	}
+	today := groupToday(groupSnap)
+	if req.End < today {
+		http.Error(w, "end is in the past", http.StatusBadRequest)
+		return fmt.Errorf("away range in the past")
+	}
+
+	ref := awayRef(groupSnap.Ref, memberID)
+	a := awayRange{
+		ID:     ref.Collection("ranges").NewDoc().ID, // not a doc; borrows Firestore's id generator
+		Start:  req.Start,
+		End:    req.End,
+		Note:   req.Note,
+		MakeUp: req.MakeUp,
+	}
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return fmt.Errorf("failed to read away ranges of %s: %w", memberID, err)
+		}
+		var ranges []awayRange
+		if snap.Exists() {
+			for _, other := range awayRanges(snap.Data()) {
+				if other.End < today {
+					continue // over; drop it
+				}
+				if other.Start <= a.End && a.Start <= other.End {
+					return fmt.Errorf("%w (%s to %s)", errAwayOverlap, other.Start, other.End)
+				}
+				ranges = append(ranges, other)
+			}
+		}
+		if len(ranges) >= maxAwayRanges {
+			return fmt.Errorf("%w: at most %d upcoming away ranges", errAwayOverlap, maxAwayRanges)
+		}
+		ranges = append(ranges, a)
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+		// Merge so make_up_minutes is kept
+		return tx.Set(ref, map[string]interface{}{
+			"away":       ranges,
+			"away_until": awayUntil(ranges),
+		}, firestore.MergeAll)
+	})
+	if err != nil {
+		code := statusForError(err)
+		if errors.Is(err, errAwayOverlap) {
+			code = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Failed to set away: %v", err), code)
+		return err
+	}
+
+	moved, stuck, err := redistributeAway(ctx, groupSnap, memberID, a, req.UserID)
+	if err != nil {
+		// The range is saved; the daily job will pick the rest up
+		log.Printf("Failed to redistribute chores for %s in group %s: %v", memberID, groupID, err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":       fmt.Sprintf("%s is away from %s to %s", memberID, a.Start, a.End),
+		"away":          a,
+		"redistributed": moved,
+		"unassignable":  stuck,
+	})
+	return nil
+}
+
+// listAwayHandler lists every member's current and upcoming away ranges.
+//
+//	GET /groups/{groupId}/away?user_id=...
+func listAwayHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list away members: %v", err), statusForError(err))
+		return err
+	}
+	today := groupToday(groupSnap)
+	docs, err := groupSnap.Ref.Collection("away").Where("away_until", ">=", today).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list away members: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	owed, err := owedMinutes(ctx, groupSnap)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list away members: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	type memberAway struct {
+		UserID        string      `json:"user_id"`
+		AwayNow       bool        `json:"away_now"`
+		Ranges        []awayRange `json:"ranges"`
+		MakeUpMinutes int64       `json:"make_up_minutes"`
+	}
+	members := make([]memberAway, 0, len(docs))
+	for _, doc := range docs {
+		m := memberAway{UserID: doc.Ref.ID, Ranges: []awayRange{}, MakeUpMinutes: owed[doc.Ref.ID]}
+		for _, a := range awayRanges(doc.Data()) {
+			if a.End < today {
+				continue
+			}
+			m.Ranges = append(m.Ranges, a)
+			m.AwayNow = m.AwayNow || a.covers(today)
+		}
+		members = append(members, m)
+	}
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"members":  members,
+	})
+	return nil
+}
+
+// deleteAwayHandler removes an away range. Chores already moved stay where
+// they are.
+//
+//	DELETE /groups/{groupId}/members/{memberId}/away/{awayId}?user_id=...
+func deleteAwayHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, memberID, awayID := r.PathValue("groupId"), r.PathValue("memberId"), r.PathValue("awayId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to remove away range: %v", err), statusForError(err))
+		return err
+	}
+	if !canManageAway(groupSnap, userID, memberID) {
+		http.Error(w, "Only the member or the group owner can change someone's away ranges", http.StatusForbidden)
+		return fmt.Errorf("not allowed to change %s's away ranges", memberID)
+	}
+
+	ref := awayRef(groupSnap.Ref, memberID)
+	found := false
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read away ranges of %s: %w", memberID, err)
+		}
+		ranges := []awayRange{}
+		for _, a := range awayRanges(snap.Data()) {
+			if a.ID == awayID {
+				found = true
+				continue
+			}
+			ranges = append(ranges, a)
+		}
+		if !found {
+			return nil
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "away", Value: ranges},
+			{Path: "away_until", Value: awayUntil(ranges)},
+		})
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to remove away range: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("Away range %s not found", awayID), http.StatusNotFound)
+		return fmt.Errorf("away range %s not found", awayID)
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("Away range %s removed", awayID),
+		"away_id": awayID,
+	})
+	return nil
+}
+
+// redistributeAwayJobHandler moves chores off members who are or soon will
+// be away, for recurring chores that rolled into a range after it was set.
+// It pages through the away members until there are none left or the time
+// budget runs out; done is false in that case. Members it already handled
+// have nothing left to move, so calling it again finishes the job.
+// Meant to be hit daily by Cloud Scheduler.
+//
+//	POST /jobs/away-redistribute
+func redistributeAwayJobHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	deadline := time.Now().Add(awayJobTimeBudget)
+	// A day back so every timezone's today is covered
+	since := time.Now().UTC().AddDate(0, 0, -1).Format(dateLayout)
+	query := firestoreClient.CollectionGroup("away").
+		Where("away_until", ">=", since).
+		OrderBy("away_until", firestore.Asc).
+		Limit(awayJobBatchSize)
+
+	moved, failed := 0, 0
+	done := false
+	groups := make(map[string]*firestore.DocumentSnapshot)
+	var last *firestore.DocumentSnapshot
+pages:
+	for time.Now().Before(deadline) {
+		page := query
+		if last != nil {
+			page = query.StartAfter(last)
+		}
+		docs, err := page.Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to find away members: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		for _, doc := range docs {
+			if time.Now().After(deadline) {
+				break pages
+			}
+			last = doc
+			m, f := redistributeAwayMember(ctx, groups, doc)
+			moved += m
+			failed += f
+		}
+		if len(docs) < awayJobBatchSize {
+			done = true
+			break
+		}
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"redistributed": moved,
+		"failed":        failed,
+		"done":          done,
+	})
+	return nil
+}
+
+// redistributeAwayMember runs redistributeAway for each of the member's
+// ranges that is current or coming up, and says how many chores moved and
+// how many steps failed. groups caches the group docs across members.
+func redistributeAwayMember(ctx context.Context, groups map[string]*firestore.DocumentSnapshot, doc *firestore.DocumentSnapshot) (moved int, failed int) {
+	groupRef := doc.Ref.Parent.Parent
+	groupSnap := groups[groupRef.ID]
+	if groupSnap == nil {
+		var err error
+		if groupSnap, err = groupRef.Get(ctx); err != nil {
+			log.Printf("Failed to read group %s: %v", groupRef.ID, err)
+			return 0, 1
+		}
+		groups[groupRef.ID] = groupSnap
+	}
+	if _, deleted := groupSnap.Data()["deleted_at"]; deleted {
+		return 0, 0
+	}
+
+	today := groupToday(groupSnap)
+	t, _ := time.Parse(dateLayout, today)
+	horizon := t.AddDate(0, 0, awayLookaheadDays).Format(dateLayout)
+	for _, a := range awayRanges(doc.Data()) {
+		if a.End < today || a.Start > horizon {
+			continue
+		}
+		ids, _, err := redistributeAway(ctx, groupSnap, doc.Ref.ID, a, "system")
+		moved += len(ids)
+		if err != nil {
+			log.Printf("Failed to redistribute chores for %s in group %s: %v", doc.Ref.ID, groupRef.ID, err)
+			failed++
+		}
+	}
+	return moved, failed
+}
@@ -0,0 +1,91 @@
+package chores
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// notification is an in-app message for one member. It goes in the same
+// groups/{groupId}/notifications queue the Group function uses, with
+// deliver_after pushed past the group's quiet hours.
+type notification struct {
+	Type  string // e.g. "swap_requested"
+	To    string // recipient uid
+	Title string
+	Body  string
+}
+
+// queueNotification stores n for the group. Failing to notify never fails
+// the action that caused it, so errors are only logged.
+func queueNotification(ctx context.Context, groupSnap *firestore.DocumentSnapshot, n notification) {
+	now := time.Now()
+	deliverAfter := deferForQuietHours(groupSnap, now)
+
+	_, _, err := groupSnap.Ref.Collection("notifications").Add(ctx, map[string]interface{}{
+		"type":          n.Type,
+		"channel":       "in_app",
+		"to":            n.To,
+		"title":         n.Title,
+		"body":          n.Body,
+		"status":        "queued",
+		"attempts":      0,
+		"deferred":      deliverAfter.After(now),
+		"deliver_after": deliverAfter,
+		"created_at":    firestore.ServerTimestamp,
+	})
+	if err != nil {
+		log.Printf("Failed to queue %s notification for %s in group %s: %v", n.Type, n.To, groupSnap.Ref.ID, err)
+	}
+}
+
+// deferForQuietHours returns t, or when the group's settings.quiet_hours
+// end if t falls inside them. The window may wrap midnight. start and end
+// are HH:MM, as the Group function stores them; if either isn't, the group
+// has no quiet hours.
+func deferForQuietHours(groupSnap *firestore.DocumentSnapshot, t time.Time) time.Time {
+	startV, err1 := groupSnap.DataAt("settings.quiet_hours.start")
+	endV, err2 := groupSnap.DataAt("settings.quiet_hours.end")
+	if err1 != nil || err2 != nil {
+		return t
+	}
+	start, err1 := parseClock(startV)
+	end, err2 := parseClock(endV)
+	if err1 != nil || err2 != nil || start == end {
+		return t
+	}
+
+	local := t.In(groupLocation(groupSnap))
+	now := local.Hour()*60 + local.Minute()
+	inside := now >= start && now < end
+	if start > end {
+		inside = now >= start || now < end
+	}
+	if !inside {
+		return t
+	}
+
+	release := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, local.Location())
+	if !release.After(local) {
+		release = release.AddDate(0, 0, 1)
+	}
+	return release
+}
+
+// parseClock reads an HH:MM value (24 hour, zero padded, e.g. "07:30")
+// into minutes after midnight. It accepts exactly what the Group
+// function's settings validation does, so "7:30" is rejected here too.
+func parseClock(v interface{}) (int, error) {
+	s, _ := v.(string)
+	if len(s) != 5 {
+		return 0, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,170 @@
+package claiminvites
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var firestoreClient *firestore.Client
+
+/*
+	Runs when a Firebase Auth user is created (alongside the JS
+	createUserProfile trigger) and turns any invites that were sent to the
+	new user's email or phone number before they had an account into normal
+	pending invites under users/{uid}/group_invites.
+
+	The invites themselves are written by the Group function's invite
+	endpoint into /invites with a normalized contact (lowercase email or
+	+E.164 phone number).
+*/
+
+// AuthEvent is the payload of a providers/firebase.auth/eventTypes/user.create event.
+type AuthEvent struct {
+	UID         string `json:"uid"`
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phoneNumber"`
+	Metadata    struct {
+		CreatedAt time.Time `json:"createdAt"`
+	} `json:"metadata"`
+}
+
+// normalizePhone matches the Group function's normalization: a leading +
+// followed by digits only.
+func normalizePhone(phone string) string {
+	var b strings.Builder
+	for i, c := range strings.TrimSpace(phone) {
+		if (c == '+' && i == 0) || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// ClaimPendingInvites surfaces invites addressed to the new user's email or phone.
+func ClaimPendingInvites(ctx context.Context, e AuthEvent) error {
+	if e.UID == "" {
+		return fmt.Errorf("auth event has no uid")
+	}
+
+	var contacts []string
+	if e.Email != "" {
+		contacts = append(contacts, strings.ToLower(strings.TrimSpace(e.Email)))
+	}
+	if e.PhoneNumber != "" {
+		contacts = append(contacts, normalizePhone(e.PhoneNumber))
+	}
+
+	claimed := 0
+	for _, contact := range contacts {
+		iter := firestoreClient.Collection("invites").
+			Where("contact", "==", contact).
+			Where("status", "==", "pending").
+			Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return fmt.Errorf("error reading invites for %s: %v", e.UID, err)
+			}
+			ok, err := claimInvite(ctx, doc.Ref, e.UID)
+			if err != nil {
+				// Keep going; the other invites can still be claimed
+				log.Printf("Failed to claim invite %s for user %s: %v", doc.Ref.ID, e.UID, err)
+				continue
+			}
+			if ok {
+				claimed++
+			}
+		}
+		iter.Stop()
+	}
+
+	log.Printf("Claimed %d pending invites for user %s", claimed, e.UID)
+	return nil
+}
+
+// claimInvite moves one invite into users/{uid}/group_invites. It returns
+// false if the invite was no longer pending or had expired, or its group
+// is gone or deleted (a deleted group's invites are left pending in case
+// the owner restores it).
+func claimInvite(ctx context.Context, inviteRef *firestore.DocumentRef, uid string) (bool, error) {
+	claimed := false
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		claimed = false
+
+		snap, err := tx.Get(inviteRef)
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		if s, _ := data["status"].(string); s != "pending" {
+			return nil
+		}
+
+		if exp, ok := data["expires_at"].(time.Time); ok && time.Now().After(exp) {
+			return tx.Update(inviteRef, []firestore.Update{
+				{Path: "status", Value: "expired"},
+			})
+		}
+
+		groupID, _ := data["group_id"].(string)
+		if groupID == "" {
+			return fmt.Errorf("invite %s has no group_id", inviteRef.ID)
+		}
+
+		groupSnap, err := tx.Get(firestoreClient.Collection("groups").Doc(groupID))
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, deleted := groupSnap.Data()["deleted_at"]; deleted {
+			return nil
+		}
+
+		userInviteRef := firestoreClient.Collection("users").Doc(uid).Collection("group_invites").Doc(groupID)
+		if err := tx.Set(userInviteRef, map[string]interface{}{
+			"group_id":   groupID,
+			"status":     "pending",
+			"sent_at":    data["sent_at"],
+			"sent_from":  data["sent_from"],
+			"expires_at": data["expires_at"],
+			"invite_id":  inviteRef.ID,
+			"token":      data["token"],
+		}); err != nil {
+			return err
+		}
+
+		claimed = true
+		return tx.Update(inviteRef, []firestore.Update{
+			{Path: "status", Value: "claimed"},
+			{Path: "claimed_by", Value: uid},
+			{Path: "claimed_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	return claimed, err
+}
+
+func init() {
+	ctx := context.Background()
+	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+
+	var err error
+	firestoreClient, err = firestore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to initialize Firestore client: %v", err)
+	}
+}
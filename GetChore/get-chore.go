@@ -3,34 +3,185 @@ package getchore
 import (
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "log"
     "net/http"
     "os"
+    "sort"
+    "strconv"
+    "strings"
 
     "cloud.google.com/go/firestore"
     "github.com/GoogleCloudPlatform/functions-framework-go/functions"
     "google.golang.org/api/iterator"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
 )
 
 var firestoreClient *firestore.Client
 
+// errGroupDeleted is returned for groups the Group function has soft
+// deleted (deleted_at set); their chores aren't shown any more
+var errGroupDeleted = errors.New("group has been deleted")
+
 // Chore struct
 type choreData struct {
-    UserID        string `json:"user_id"`
-    GroupID       string `json:"group_id"`
-    ChoreName     string `json:"chore_name"`
-    ChoreDetails  string `json:"chore_details"`
-    ChoreDueDate  string `json:"chore_due_date"`
-    ChoreFreq     string `json:"chore_frequency"`
-    ChoreAssignee string `json:"chore_assignee"`
+    UserID           string   `json:"user_id"`
+    GroupID          string   `json:"group_id"`
+    ChoreID          string   `json:"chore_id"`
+    ChoreName        string   `json:"chore_name"`
+    ChoreDetails     string   `json:"chore_details"`
+    ChoreDueDate     string   `json:"chore_due_date"`
+    ChoreFreq        string   `json:"chore_frequency"`
+    ChoreAssignee    string   `json:"chore_assignee"`
+    ChoreStatus      string   `json:"chore_status"`
+    Assignees        []string `json:"assignees"`
+    Priority         int64    `json:"priority"` // 0 (none) to 3 (high)
+    Tags             []string `json:"tags"`
+    EstimatedMinutes int64    `json:"estimated_minutes"`
+}
+
+// choreQuery is the optional filters and sort order for listing chores
+type choreQuery struct {
+    Tag         string // only chores with this tag
+    MinPriority int64  // only chores at least this important
+    Assignee    string // only chores assigned to this user
+    Status      string // only chores with this chore_status
+    Sort        string // chore_due_date (default), priority, estimated_minutes or chore_name
+    Desc        bool
+}
+
+var sortKeys = map[string]bool{
+    "chore_due_date":    true,
+    "priority":          true,
+    "estimated_minutes": true,
+    "chore_name":        true,
+}
+
+// parseChoreQuery reads ?tag=&min_priority=&assignee=&status=&sort=&order=
+func parseChoreQuery(r *http.Request) (choreQuery, error) {
+    q := choreQuery{
+        Tag:      strings.ToLower(r.URL.Query().Get("tag")),
+        Assignee: r.URL.Query().Get("assignee"),
+        Status:   r.URL.Query().Get("status"),
+        Sort:     r.URL.Query().Get("sort"),
+    }
+    if p := r.URL.Query().Get("min_priority"); p != "" {
+        n, err := strconv.ParseInt(p, 10, 64)
+        if err != nil || n < 0 || n > 3 {
+            return q, fmt.Errorf("min_priority must be between 0 and 3")
+        }
+        q.MinPriority = n
+    }
+    if q.Sort == "" {
+        q.Sort = "chore_due_date"
+    }
+    if !sortKeys[q.Sort] {
+        return q, fmt.Errorf("sort must be chore_due_date, priority, estimated_minutes or chore_name")
+    }
+    switch r.URL.Query().Get("order") {
+    case "", "asc":
+    case "desc":
+        q.Desc = true
+    default:
+        return q, fmt.Errorf("order must be asc or desc")
+    }
+    return q, nil
+}
+
+// matches reports whether a chore passes the filters
+func (q choreQuery) matches(c choreData) bool {
+    if q.Tag != "" && !contains(c.Tags, q.Tag) {
+        return false
+    }
+    if c.Priority < q.MinPriority {
+        return false
+    }
+    if q.Assignee != "" && !contains(c.Assignees, q.Assignee) {
+        return false
+    }
+    if q.Status != "" && c.ChoreStatus != q.Status {
+        return false
+    }
+    return true
+}
+
+// sortChores orders chores by the query's sort key, ties broken by due date
+// then name so the order is stable
+func (q choreQuery) sortChores(chores []choreData) {
+    less := func(a, b choreData) bool {
+        switch q.Sort {
+        case "priority":
+            if a.Priority != b.Priority {
+                return a.Priority < b.Priority
+            }
+        case "estimated_minutes":
+            if a.EstimatedMinutes != b.EstimatedMinutes {
+                return a.EstimatedMinutes < b.EstimatedMinutes
+            }
+        case "chore_name":
+            if a.ChoreName != b.ChoreName {
+                return strings.ToLower(a.ChoreName) < strings.ToLower(b.ChoreName)
+            }
+        }
+        if a.ChoreDueDate != b.ChoreDueDate {
+            return a.ChoreDueDate < b.ChoreDueDate
+        }
+        return a.ChoreName < b.ChoreName
+    }
+    sort.SliceStable(chores, func(i, j int) bool {
+        if q.Desc {
+            return less(chores[j], chores[i])
+        }
+        return less(chores[i], chores[j])
+    })
+}
+
+func contains(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
+
+// stringList reads a firestore array of strings
+func stringList(v interface{}) []string {
+    items, _ := v.([]interface{})
+    list := make([]string, 0, len(items))
+    for _, item := range items {
+        if s, ok := item.(string); ok && s != "" {
+            list = append(list, s)
+        }
+    }
+    return list
 }
 
 // Get chore data from a group
-func getChoreFromGroup(ctx context.Context, client *firestore.Client, groupID string) ([]choreData, error) {
-    var chores []choreData
+func getChoreFromGroup(ctx context.Context, client *firestore.Client, groupID string, q choreQuery) ([]choreData, error) {
+    chores := []choreData{}
+
+    // A group that doesn't exist simply has no chores, but a deleted one is
+    // gone for its members too
+    groupSnap, err := client.Collection("groups").Doc(groupID).Get(ctx)
+    if err != nil && status.Code(err) != codes.NotFound {
+        return nil, fmt.Errorf("error reading group %s: %v", groupID, err)
+    }
+    if err == nil {
+        if _, deleted := groupSnap.Data()["deleted_at"]; deleted {
+            return nil, errGroupDeleted
+        }
+    }
 
-    iter := client.Collection("groups").Doc(groupID).Collection("chores").Documents(ctx)
+    // A tag filter can be done by firestore (array-contains); the rest are
+    // applied below
+    query := client.Collection("groups").Doc(groupID).Collection("chores").Query
+    if q.Tag != "" {
+        query = query.Where("tags", "array-contains", q.Tag)
+    }
+    iter := query.Documents(ctx)
 	fmt.Printf("In the getChoreFromGroup function ")
     defer iter.Stop()
 
@@ -48,9 +199,12 @@ func getChoreFromGroup(ctx context.Context, client *firestore.Client, groupID st
             return nil, err
         }
 
-        chores = append(chores, c)
+        if q.matches(c) {
+            chores = append(chores, c)
+        }
     }
 
+    q.sortChores(chores)
     return chores, nil
 }
 
@@ -58,6 +212,7 @@ func getChoreDoc(doc *firestore.DocumentSnapshot) (choreData, error) {
     var c choreData
 
     data := doc.Data()
+    c.ChoreID = doc.Ref.ID
 
     if v, ok := data["user_id"].(string); ok {
         c.UserID = v
@@ -80,6 +235,21 @@ func getChoreDoc(doc *firestore.DocumentSnapshot) (choreData, error) {
     if v, ok := data["chore_assignee"].(string); ok {
         c.ChoreAssignee = v
     }
+    if v, ok := data["chore_status"].(string); ok {
+        c.ChoreStatus = v
+    }
+    if v, ok := data["priority"].(int64); ok {
+        c.Priority = v
+    }
+    if v, ok := data["estimated_minutes"].(int64); ok {
+        c.EstimatedMinutes = v
+    }
+    c.Tags = stringList(data["tags"])
+    c.Assignees = stringList(data["assignees"])
+    if len(c.Assignees) == 0 && c.ChoreAssignee != "" {
+        // chores from before assignees existed
+        c.Assignees = []string{c.ChoreAssignee}
+    }
 
     return c, nil
 }
@@ -115,7 +285,17 @@ func GetChoreHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-    chores, err := getChoreFromGroup(ctx, firestoreClient, groupID)
+    q, err := parseChoreQuery(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    chores, err := getChoreFromGroup(ctx, firestoreClient, groupID, q)
+    if errors.Is(err, errGroupDeleted) {
+        http.Error(w, fmt.Sprintf("Error fetching chores: %v", err), http.StatusGone)
+        return
+    }
     if err != nil {
         http.Error(w, fmt.Sprintf("Error fetching chores: %v", err), http.StatusInternalServerError)
         return
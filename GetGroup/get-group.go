@@ -7,6 +7,7 @@ import (
     "log"
     "net/http"
     "os"
+    "strings"
 
     "cloud.google.com/go/firestore"
     "github.com/GoogleCloudPlatform/functions-framework-go/functions"
@@ -22,9 +23,11 @@ type groupData struct {
     UserID        string `json:"user_id"`
 }
 
-// Get group data from a group
+// Get group data from a group. Groups the owner has deleted (deleted_at set
+// on the group doc) are left out while they wait to be purged.
 func getGroupFromMyGroups(ctx context.Context, client *firestore.Client, uid string) ([]groupData, error) {
     var groups []groupData
+    var groupRefs []*firestore.DocumentRef
 
     iter := client.Collection("users").Doc(uid).Collection("my_groups").Documents(ctx)
 	fmt.Printf("In the getGroupFromMyGroups function ")
@@ -44,10 +47,33 @@ func getGroupFromMyGroups(ctx context.Context, client *firestore.Client, uid str
             return nil, err
         }
 
+        // my_groups stores the group as "/groups/{id}"
+        groupID := strings.TrimPrefix(g.GroupID, "/groups/")
+        if groupID == "" {
+            continue
+        }
         groups = append(groups, g)
+        groupRefs = append(groupRefs, client.Collection("groups").Doc(groupID))
+    }
+
+    if len(groupRefs) == 0 {
+        return groups, nil
+    }
+    snaps, err := client.GetAll(ctx, groupRefs)
+    if err != nil {
+        return nil, fmt.Errorf("error reading groups for user %s: %v", uid, err)
+    }
+    visible := groups[:0]
+    for i, snap := range snaps {
+        if snap.Exists() {
+            if _, deleted := snap.Data()["deleted_at"]; deleted {
+                continue
+            }
+        }
+        visible = append(visible, groups[i])
     }
 
-    return groups, nil
+    return visible, nil
 }
 
 func getGroupDoc(doc *firestore.DocumentSnapshot) (groupData, error) {
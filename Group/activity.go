@@ -0,0 +1,225 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	The group's activity feed, newest first:
+
+	/groups/{groupId}/activity/{entryId}
+		type, actor, at, and depending on the type
+		member, added_by (member_joined),
+		member, contact_type (invite_sent; member is empty for invites to an
+		email / phone, which aren't shown to the group),
+		chore_id, chore_name, history_id, member, assignees, part,
+		verified_by, reason (chore_*; written by the chore function; reason
+		is "away", "claimed", "unclaimed", "auto_assigned" or "swapped" on
+		chore_reassigned)
+
+	Members joining and invites are recorded here. The chore function adds
+	chore_created, chore_completed, chore_reassigned and chore_deleted to
+	the same collection.
+*/
+
+const (
+	defaultActivityPage = 50
+	maxActivityPage     = 100
+	maxActivityTypes    = 10 // Firestore's limit for "in"
+)
+
+var activityTypes = map[string]bool{
+	"member_joined":    true,
+	"invite_sent":      true,
+	"chore_created":    true,
+	"chore_completed":  true,
+	"chore_reassigned": true,
+	"chore_deleted":    true,
+}
+
+// activityEntry is one feed entry as the app shows it.
+type activityEntry struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Actor       string   `json:"actor"`
+	ActorName   string   `json:"actor_name,omitempty"`
+	At          string   `json:"at,omitempty"`
+	Member      string   `json:"member,omitempty"`
+	MemberName  string   `json:"member_name,omitempty"`
+	AddedBy     string   `json:"added_by,omitempty"`
+	ContactType string   `json:"contact_type,omitempty"`
+	ChoreID     string   `json:"chore_id,omitempty"`
+	ChoreName   string   `json:"chore_name,omitempty"`
+	HistoryID   string   `json:"history_id,omitempty"`
+	Assignees   []string `json:"assignees,omitempty"`
+	Part        bool     `json:"part,omitempty"`
+	VerifiedBy  string   `json:"verified_by,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+func activityEntryFromSnapshot(snap *firestore.DocumentSnapshot) activityEntry {
+	data := snap.Data()
+	e := activityEntry{ID: snap.Ref.ID}
+	e.Type, _ = data["type"].(string)
+	e.Actor, _ = data["actor"].(string)
+	e.Member, _ = data["member"].(string)
+	e.AddedBy, _ = data["added_by"].(string)
+	e.ContactType, _ = data["contact_type"].(string)
+	e.ChoreID, _ = data["chore_id"].(string)
+	e.ChoreName, _ = data["chore_name"].(string)
+	e.HistoryID, _ = data["history_id"].(string)
+	e.Part, _ = data["part"].(bool)
+	e.VerifiedBy, _ = data["verified_by"].(string)
+	e.Reason, _ = data["reason"].(string)
+	if list, ok := data["assignees"].([]interface{}); ok {
+		for _, v := range list {
+			if s, _ := v.(string); s != "" {
+				e.Assignees = append(e.Assignees, s)
+			}
+		}
+	}
+	if t, ok := data["at"].(time.Time); ok {
+		e.At = t.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// activityDoc fills in the fields every entry has.
+func activityDoc(typ string, actor string, fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	fields["type"] = typ
+	fields["actor"] = actor
+	fields["at"] = firestore.ServerTimestamp
+	return fields
+}
+
+// recordActivity adds an entry to the group's feed. The feed is a nice to
+// have, so failing to write it never fails the action; errors are logged.
+func recordActivity(ctx context.Context, groupID string, typ string, actor string, fields map[string]interface{}) {
+	ref := firestoreClient.Collection("groups").Doc(groupID).Collection("activity")
+	if _, _, err := ref.Add(ctx, activityDoc(typ, actor, fields)); err != nil {
+		log.Printf("Failed to record %s activity in group %s: %v", typ, groupID, err)
+	}
+}
+
+// activityHandler pages through the group's feed, newest first. type takes
+// one type or a comma separated list; actor only shows what one member did.
+// Pass the next_page_token of one page as page_token to get the next.
+//
+//	GET /groups/{groupId}/activity?user_id=...&type=...&actor=...&limit=50&page_token=...
+func activityHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	userID := query.Get("user_id")
+	groupID := r.PathValue("groupId")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	limit := defaultActivityPage
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxActivityPage {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxActivityPage), http.StatusBadRequest)
+			return fmt.Errorf("invalid limit %q", s)
+		}
+		limit = n
+	}
+	var types []string
+	if s := query.Get("type"); s != "" {
+		for _, t := range strings.Split(s, ",") {
+			t = strings.TrimSpace(t)
+			if !activityTypes[t] {
+				http.Error(w, fmt.Sprintf("Unknown activity type %q", t), http.StatusBadRequest)
+				return fmt.Errorf("unknown activity type %q", t)
+			}
+			types = append(types, t)
+		}
+		if len(types) > maxActivityTypes {
+			http.Error(w, fmt.Sprintf("Filter on at most %d types", maxActivityTypes), http.StatusBadRequest)
+			return fmt.Errorf("too many activity types")
+		}
+	}
+	actor := query.Get("actor")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read activity: %v", err), statusForError(err))
+		return err
+	}
+
+	col := groupSnap.Ref.Collection("activity")
+	q := col.Query
+	switch len(types) {
+	case 0:
+	case 1:
+		q = q.Where("type", "==", types[0])
+	default:
+		q = q.Where("type", "in", types)
+	}
+	if actor != "" {
+		q = q.Where("actor", "==", actor)
+	}
+	q = q.OrderBy("at", firestore.Desc)
+	if token := query.Get("page_token"); token != "" {
+		// The token is the ID of the last entry on the previous page
+		last, err := col.Doc(token).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "Invalid page_token", http.StatusBadRequest)
+			return fmt.Errorf("unknown page_token %q", token)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read activity: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		q = q.StartAfter(last)
+	}
+
+	// One extra tells us whether there's another page
+	docs, err := q.Limit(limit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read activity: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	nextToken := ""
+	if len(docs) > limit {
+		docs = docs[:limit]
+		nextToken = docs[limit-1].Ref.ID
+	}
+
+	entries := make([]activityEntry, 0, len(docs))
+	var userIDs []string
+	for _, doc := range docs {
+		e := activityEntryFromSnapshot(doc)
+		entries = append(entries, e)
+		userIDs = append(userIDs, e.Actor, e.Member)
+	}
+	names, err := lookupNames(ctx, firestoreClient.Collection("users"), userIDs, "user_name")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read member names: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	for i := range entries {
+		entries[i].ActorName = names[entries[i].Actor]
+		entries[i].MemberName = names[entries[i].Member]
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"group_id":        groupID,
+		"activity":        entries,
+		"next_page_token": nextToken,
+	})
+	return nil
+}
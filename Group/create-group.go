@@ -56,17 +56,16 @@ func saveGroupToFirestore(ctx context.Context, client *firestore.Client, groupIn
 // required fields: group_id
 func createGroupHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed; only POST is supported", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var RequestBody struct {
 		UserID				string  `json:"user_id"`		// required	
 	}
 
+	// Reject anything that isn't a create body (e.g. an accept payload)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
 	// If decoding the request body fails
-    if err := json.NewDecoder(r.Body).Decode(&RequestBody); err != nil {
+    if err := dec.Decode(&RequestBody); err != nil {
         http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
         return
     }
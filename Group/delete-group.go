@@ -0,0 +1,360 @@
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	Deleting a group is a two step process:
+
+	- delete marks the group with deleted_at / purge_after, and the owner can
+	  still restore it during the grace period. While it is marked the chore
+	  and list functions answer 410 Gone for it and GetChore / GetGroup
+	  leave it out, so members stop seeing it
+	- purge removes the group doc, every subcollection under it, the
+	  my_groups / group_invites mirrors that point at it, and its contact
+	  invites (/invites) and join codes (/join_codes)
+
+	Firestore doesn't delete subcollections with their parent, so purge walks
+	them in bounded batches. If it runs out of time it returns done=false and
+	can simply be called again; every step only deletes what is left.
+*/
+
+const (
+	defaultDeleteGraceDays = 7
+	maxDeleteGraceDays     = 30
+	purgeBatchSize         = 200
+	purgeTimeBudget        = 45 * time.Second
+)
+
+// deleteGroupHandler soft deletes a group. Only the owner may delete it.
+// grace_days of 0 purges immediately.
+func deleteGroupHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID    string `json:"user_id"`
+		GroupID   string `json:"-"` // from the path
+		GraceDays *int   `json:"grace_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.GroupID = r.PathValue("groupId")
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	graceDays := defaultDeleteGraceDays
+	if req.GraceDays != nil {
+		graceDays = *req.GraceDays
+	}
+	if graceDays < 0 || graceDays > maxDeleteGraceDays {
+		http.Error(w, fmt.Sprintf("grace_days must be between 0 and %d", maxDeleteGraceDays), http.StatusBadRequest)
+		return fmt.Errorf("invalid grace_days %d", graceDays)
+	}
+
+	snap, err := requireGroupOwner(ctx, req.GroupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete group: %v", err), statusForError(err))
+		return err
+	}
+
+	purgeAfter := time.Now().Add(time.Duration(graceDays) * 24 * time.Hour)
+	if groupIsDeleted(snap) {
+		// Already deleted; keep the original grace period.
+		if t, ok := snap.Data()["purge_after"].(time.Time); ok {
+			purgeAfter = t
+		}
+	} else {
+		_, err = snap.Ref.Update(ctx, []firestore.Update{
+			{Path: "deleted_at", Value: firestore.ServerTimestamp},
+			{Path: "deleted_by", Value: req.UserID},
+			{Path: "purge_after", Value: purgeAfter},
+		})
+		if err != nil {
+			log.Printf("Failed to mark group %s deleted: %v", req.GroupID, err)
+			http.Error(w, fmt.Sprintf("Failed to delete group: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		log.Printf("Group %s deleted by %s, purge after %s", req.GroupID, req.UserID, purgeAfter.Format(time.RFC3339))
+	}
+
+	if graceDays == 0 {
+		done, err := purgeGroup(ctx, snap.Ref)
+		if err != nil {
+			log.Printf("Failed to purge group %s: %v", req.GroupID, err)
+			http.Error(w, fmt.Sprintf("Failed to purge group: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		writePurgeResult(w, req.GroupID, done)
+		return nil
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message":     fmt.Sprintf("Group %s deleted; it can be restored until %s", req.GroupID, purgeAfter.Format(time.RFC3339)),
+		"group_id":    req.GroupID,
+		"purge_after": purgeAfter,
+	})
+	return nil
+}
+
+// restoreGroupHandler undoes a soft delete while the grace period is running.
+func restoreGroupHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID  string `json:"user_id"`
+		GroupID string `json:"-"` // from the path
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.GroupID = r.PathValue("groupId")
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	snap, err := requireGroupOwner(ctx, req.GroupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to restore group: %v", err), statusForError(err))
+		return err
+	}
+	if !groupIsDeleted(snap) {
+		http.Error(w, fmt.Sprintf("Group %s is not deleted", req.GroupID), http.StatusConflict)
+		return fmt.Errorf("group %s not deleted", req.GroupID)
+	}
+	if _, started := snap.Data()["purge_started_at"]; started {
+		http.Error(w, fmt.Sprintf("Group %s is already being purged", req.GroupID), http.StatusGone)
+		return fmt.Errorf("group %s purge already started", req.GroupID)
+	}
+
+	_, err = snap.Ref.Update(ctx, []firestore.Update{
+		{Path: "deleted_at", Value: firestore.Delete},
+		{Path: "deleted_by", Value: firestore.Delete},
+		{Path: "purge_after", Value: firestore.Delete},
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to restore group: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	log.Printf("Group %s restored by %s", req.GroupID, req.UserID)
+	writeJSON(w, map[string]string{
+		"message":  fmt.Sprintf("Group %s restored", req.GroupID),
+		"group_id": req.GroupID,
+	})
+	return nil
+}
+
+// purgeGroupHandler lets the owner finish deleting a group once its grace
+// period is over. It is safe to call repeatedly until done is true.
+func purgeGroupHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID  string `json:"user_id"`
+		GroupID string `json:"-"` // from the path
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.GroupID = r.PathValue("groupId")
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	snap, err := requireGroupOwner(ctx, req.GroupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to purge group: %v", err), statusForError(err))
+		return err
+	}
+	if !groupIsDeleted(snap) {
+		http.Error(w, fmt.Sprintf("Group %s must be deleted before it can be purged", req.GroupID), http.StatusConflict)
+		return fmt.Errorf("group %s not deleted", req.GroupID)
+	}
+	if t, ok := snap.Data()["purge_after"].(time.Time); ok && time.Now().Before(t) {
+		http.Error(w, fmt.Sprintf("Group %s can't be purged until %s", req.GroupID, t.Format(time.RFC3339)), http.StatusConflict)
+		return fmt.Errorf("group %s still in grace period", req.GroupID)
+	}
+
+	done, err := purgeGroup(ctx, snap.Ref)
+	if err != nil {
+		log.Printf("Failed to purge group %s: %v", req.GroupID, err)
+		http.Error(w, fmt.Sprintf("Failed to purge group: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	writePurgeResult(w, req.GroupID, done)
+	return nil
+}
+
+// purgeExpiredGroupsHandler purges every deleted group whose grace period is
+// over, at most purgeBatchSize per call; done is false while there may be
+// more left. Meant to be hit by Cloud Scheduler.
+func purgeExpiredGroupsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	deadline := time.Now().Add(purgeTimeBudget)
+	docs, err := firestoreClient.Collection("groups").
+		Where("purge_after", "<=", time.Now()).
+		Limit(purgeBatchSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to query expired groups: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	purged := []string{}
+	for _, doc := range docs {
+		if time.Now().After(deadline) {
+			break
+		}
+		done, err := purgeGroupUntil(ctx, doc.Ref, deadline)
+		if err != nil {
+			log.Printf("Failed to purge group %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if done {
+			purged = append(purged, doc.Ref.ID)
+		}
+	}
+
+	// A full batch may have left expired groups behind the limit
+	writeJSON(w, map[string]interface{}{
+		"purged": purged,
+		"done":   len(purged) == len(docs) && len(docs) < purgeBatchSize,
+	})
+	return nil
+}
+
+func writePurgeResult(w http.ResponseWriter, groupID string, done bool) {
+	if !done {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":  fmt.Sprintf("Group %s is partially purged; call purge again to resume", groupID),
+			"group_id": groupID,
+			"done":     false,
+		})
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"message":  fmt.Sprintf("Group %s purged", groupID),
+		"group_id": groupID,
+		"done":     true,
+	})
+}
+
+// purgeGroup removes a group and everything hanging off it within
+// purgeTimeBudget. It returns false if there is still work left.
+func purgeGroup(ctx context.Context, groupRef *firestore.DocumentRef) (bool, error) {
+	return purgeGroupUntil(ctx, groupRef, time.Now().Add(purgeTimeBudget))
+}
+
+func purgeGroupUntil(ctx context.Context, groupRef *firestore.DocumentRef, deadline time.Time) (bool, error) {
+	// Record that a purge started so the group can no longer be restored.
+	_, err := groupRef.Update(ctx, []firestore.Update{
+		{Path: "purge_started_at", Value: firestore.ServerTimestamp},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to mark purge start: %w", err)
+	}
+
+	// 1) Mirrors in users/{uid}/my_groups
+	myGroups := firestoreClient.CollectionGroup("my_groups").Where("group_id", "==", "/groups/"+groupRef.ID)
+	if done, err := deleteQuery(ctx, myGroups, false, deadline); err != nil || !done {
+		return false, err
+	}
+
+	// 2) Pending invites in users/{uid}/group_invites
+	invites := firestoreClient.CollectionGroup("group_invites").Where("group_id", "==", groupRef.ID)
+	if done, err := deleteQuery(ctx, invites, false, deadline); err != nil || !done {
+		return false, err
+	}
+
+	// 3) Contact invites in /invites and join codes in /join_codes, so a
+	//    new account can't claim an invite to a group that is gone
+	contactInvites := firestoreClient.Collection("invites").Where("group_id", "==", groupRef.ID)
+	if done, err := deleteQuery(ctx, contactInvites, false, deadline); err != nil || !done {
+		return false, err
+	}
+	joinCodes := firestoreClient.Collection("join_codes").Where("group_id", "==", groupRef.ID)
+	if done, err := deleteQuery(ctx, joinCodes, false, deadline); err != nil || !done {
+		return false, err
+	}
+
+	// 4) chores, members and anything else under the group
+	if done, err := deleteSubcollections(ctx, groupRef, deadline); err != nil || !done {
+		return false, err
+	}
+
+	// 5) The group itself
+	if _, err := groupRef.Delete(ctx); err != nil {
+		return false, fmt.Errorf("failed to delete group doc: %w", err)
+	}
+	log.Printf("Group %s purged", groupRef.ID)
+	return true, nil
+}
+
+// deleteSubcollections recursively deletes every subcollection of ref.
+func deleteSubcollections(ctx context.Context, ref *firestore.DocumentRef, deadline time.Time) (bool, error) {
+	cols, err := ref.Collections(ctx).GetAll()
+	if err != nil {
+		return false, fmt.Errorf("failed to list subcollections of %s: %w", ref.Path, err)
+	}
+	for _, col := range cols {
+		if done, err := deleteQuery(ctx, col.Query, true, deadline); err != nil || !done {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// deleteQuery deletes everything q matches, purgeBatchSize docs at a time.
+// With recursive set each doc's subcollections are deleted first.
+func deleteQuery(ctx context.Context, q firestore.Query, recursive bool, deadline time.Time) (bool, error) {
+	for {
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+
+		docs, err := q.Limit(purgeBatchSize).Documents(ctx).GetAll()
+		if err != nil {
+			return false, fmt.Errorf("failed to query documents to delete: %w", err)
+		}
+		if len(docs) == 0 {
+			return true, nil
+		}
+
+		if recursive {
+			for _, doc := range docs {
+				if done, err := deleteSubcollections(ctx, doc.Ref, deadline); err != nil || !done {
+					return false, err
+				}
+			}
+		}
+
+		bw := firestoreClient.BulkWriter(ctx)
+		jobs := make([]*firestore.BulkWriterJob, 0, len(docs))
+		for _, doc := range docs {
+			job, err := bw.Delete(doc.Ref)
+			if err != nil {
+				bw.End()
+				return false, fmt.Errorf("failed to queue delete of %s: %w", doc.Ref.Path, err)
+			}
+			jobs = append(jobs, job)
+		}
+		bw.End()
+
+		for _, job := range jobs {
+			if _, err := job.Results(); err != nil {
+				return false, fmt.Errorf("failed to delete document: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	_ "time/tzdata" // the Cloud Functions image doesn't ship a zoneinfo database
+
+	"cloud.google.com/go/firestore"
+)
+
+const maxHouseRulesLength = 10000
+
+// quietHours is a daily window, in the group's timezone, during which
+// notifications are held back. The window may wrap midnight (22:00 - 07:00).
+// Only queued notifications are deferred: there is no reminder scheduler
+// yet (chores only record reminder snoozes), so whatever sends reminders
+// later has to apply the window itself.
+type quietHours struct {
+	Start string `json:"start" firestore:"start"` // HH:MM
+	End   string `json:"end" firestore:"end"`     // HH:MM
+}
+
+// snoozeLimits bound how much members can snooze chores. Unset fields use
+// the chore function's defaults (3 a week, 3 days, 24 hours).
+type snoozeLimits struct {
+	PerWeek    *int `json:"per_week,omitempty" firestore:"per_week,omitempty"` // 0 turns due date pushes off
+	MaxDays    *int `json:"max_days,omitempty" firestore:"max_days,omitempty"`
+	MaxMinutes *int `json:"max_minutes,omitempty" firestore:"max_minutes,omitempty"`
+}
+
+func (l snoozeLimits) validate() error {
+	if l.PerWeek != nil && (*l.PerWeek < 0 || *l.PerWeek > 50) {
+		return fmt.Errorf("per_week must be between 0 and 50")
+	}
+	if l.MaxDays != nil && (*l.MaxDays < 1 || *l.MaxDays > 30) {
+		return fmt.Errorf("max_days must be between 1 and 30")
+	}
+	if l.MaxMinutes != nil && (*l.MaxMinutes < 5 || *l.MaxMinutes > 7*24*60) {
+		return fmt.Errorf("max_minutes must be between 5 and %d", 7*24*60)
+	}
+	return nil
+}
+
+// groupSettings is stored under the "settings" field of groups/{groupId}.
+type groupSettings struct {
+	HouseRules string      `json:"house_rules" firestore:"house_rules"`
+	QuietHours *quietHours `json:"quiet_hours,omitempty" firestore:"quiet_hours,omitempty"`
+	Timezone   string      `json:"timezone" firestore:"timezone"` // IANA name, e.g. America/New_York
+	// completed chores wait for another member to check them; chores can
+	// override it with requires_verification
+	RequireVerification bool          `json:"require_verification" firestore:"require_verification"`
+	SnoozeLimits        *snoozeLimits `json:"snooze_limits,omitempty" firestore:"snooze_limits,omitempty"`
+}
+
+// settingsFromSnapshot reads the settings map off a group doc. Missing
+// settings come back as the zero value.
+func settingsFromSnapshot(snap *firestore.DocumentSnapshot) groupSettings {
+	var doc struct {
+		Settings groupSettings `firestore:"settings"`
+	}
+	if err := snap.DataTo(&doc); err != nil {
+		log.Printf("Failed to read settings for group %s: %v", snap.Ref.ID, err)
+		return groupSettings{}
+	}
+	return doc.Settings
+}
+
+// parseClock parses an HH:MM string into minutes after midnight.
+func parseClock(s string) (int, error) {
+	if len(s) != 5 {
+		return 0, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (q quietHours) validate() error {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return fmt.Errorf("quiet_hours.start: %w", err)
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		return fmt.Errorf("quiet_hours.end: %w", err)
+	}
+	if start == end {
+		return fmt.Errorf("quiet_hours start and end can't be the same")
+	}
+	return nil
+}
+
+// location returns the group's timezone, falling back to UTC.
+func (s groupSettings) location() *time.Location {
+	if s.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// deferForQuietHours returns t if it falls outside the group's quiet hours,
+// otherwise the time quiet hours end.
+func (s groupSettings) deferForQuietHours(t time.Time) time.Time {
+	if s.QuietHours == nil {
+		return t
+	}
+	start, err := parseClock(s.QuietHours.Start)
+	if err != nil {
+		return t
+	}
+	end, err := parseClock(s.QuietHours.End)
+	if err != nil {
+		return t
+	}
+
+	local := t.In(s.location())
+	now := local.Hour()*60 + local.Minute()
+
+	var inside bool
+	if start < end {
+		inside = now >= start && now < end
+	} else {
+		// window wraps midnight
+		inside = now >= start || now < end
+	}
+	if !inside {
+		return t
+	}
+
+	release := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, local.Location())
+	if !release.After(local) {
+		release = release.AddDate(0, 0, 1)
+	}
+	return release
+}
+
+// getGroupSettings returns a group's settings to any member.
+func getGroupSettings(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	groupID := r.PathValue("groupId")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	snap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get settings: %v", err), statusForError(err))
+		return err
+	}
+
+	settings := settingsFromSnapshot(snap)
+	if settings.Timezone == "" {
+		settings.Timezone = "UTC"
+	}
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"settings": settings,
+	})
+	return nil
+}
+
+// updateGroupSettings changes a group's settings. Owner only.
+func updateGroupSettings(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID              string        `json:"user_id"`
+		GroupID             string        `json:"-"` // from the path
+		HouseRules          *string       `json:"house_rules"`
+		QuietHours          *quietHours   `json:"quiet_hours"` // send {"start":"","end":""} to clear
+		Timezone            *string       `json:"timezone"`
+		RequireVerification *bool         `json:"require_verification"`
+		SnoozeLimits        *snoozeLimits `json:"snooze_limits"` // send {} to go back to the defaults
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.GroupID = r.PathValue("groupId")
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	var updates []firestore.Update
+	if req.HouseRules != nil {
+		if len(*req.HouseRules) > maxHouseRulesLength {
+			http.Error(w, fmt.Sprintf("house_rules can be at most %d characters", maxHouseRulesLength), http.StatusBadRequest)
+			return fmt.Errorf("house_rules too long")
+		}
+		updates = append(updates, firestore.Update{Path: "settings.house_rules", Value: *req.HouseRules})
+	}
+	if req.QuietHours != nil {
+		if req.QuietHours.Start == "" && req.QuietHours.End == "" {
+			updates = append(updates, firestore.Update{Path: "settings.quiet_hours", Value: firestore.Delete})
+		} else {
+			if err := req.QuietHours.validate(); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid quiet_hours: %v", err), http.StatusBadRequest)
+				return err
+			}
+			updates = append(updates, firestore.Update{Path: "settings.quiet_hours", Value: *req.QuietHours})
+		}
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			http.Error(w, fmt.Sprintf("Invalid timezone %q; use an IANA name like America/New_York", *req.Timezone), http.StatusBadRequest)
+			return fmt.Errorf("invalid timezone %q", *req.Timezone)
+		}
+		updates = append(updates, firestore.Update{Path: "settings.timezone", Value: *req.Timezone})
+	}
+	if req.RequireVerification != nil {
+		updates = append(updates, firestore.Update{Path: "settings.require_verification", Value: *req.RequireVerification})
+	}
+	if req.SnoozeLimits != nil {
+		if err := req.SnoozeLimits.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid snooze_limits: %v", err), http.StatusBadRequest)
+			return err
+		}
+		updates = append(updates, firestore.Update{Path: "settings.snooze_limits", Value: *req.SnoozeLimits})
+	}
+	if len(updates) == 0 {
+		http.Error(w, "Nothing to update; send house_rules, quiet_hours, timezone, require_verification or snooze_limits", http.StatusBadRequest)
+		return fmt.Errorf("no settings to update")
+	}
+
+	snap, err := requireGroupOwner(ctx, req.GroupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update settings: %v", err), statusForError(err))
+		return err
+	}
+	if groupIsDeleted(snap) {
+		http.Error(w, fmt.Sprintf("Group %s has been deleted", req.GroupID), http.StatusGone)
+		return errGroupDeleted
+	}
+
+	updates = append(updates, firestore.Update{Path: "settings.updated_at", Value: firestore.ServerTimestamp})
+	if _, err := snap.Ref.Update(ctx, updates); err != nil {
+		log.Printf("Failed to update settings for group %s: %v", req.GroupID, err)
+		http.Error(w, fmt.Sprintf("Failed to update settings: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]string{
+		"message":  "Settings updated successfully",
+		"group_id": req.GroupID,
+	})
+	return nil
+}
@@ -2,17 +2,89 @@ package group
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strings"
 
 	"cloud.google.com/go/firestore"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var firestoreClient *firestore.Client
 
+var (
+	errGroupNotFound  = errors.New("group not found")
+	errNotGroupOwner  = errors.New("only the group owner can do this")
+	errGroupDeleted   = errors.New("group has been deleted")
+	errNotGroupMember = errors.New("user is not a member of this group")
+)
+
+// requireGroupOwner loads the group and checks that uid created it.
+func requireGroupOwner(ctx context.Context, groupID string, uid string) (*firestore.DocumentSnapshot, error) {
+	snap, err := firestoreClient.Collection("groups").Doc(groupID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group %s: %w", groupID, err)
+	}
+	if owner, _ := snap.Data()["created_by"].(string); owner == "" || owner != uid {
+		return nil, errNotGroupOwner
+	}
+	return snap, nil
+}
+
+// requireGroupMember loads the group and checks that uid is the owner or
+// has a doc in its members subcollection.
+func requireGroupMember(ctx context.Context, groupID string, uid string) (*firestore.DocumentSnapshot, error) {
+	groupRef := firestoreClient.Collection("groups").Doc(groupID)
+	snap, err := groupRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group %s: %w", groupID, err)
+	}
+	if owner, _ := snap.Data()["created_by"].(string); owner != "" && owner == uid {
+		return snap, nil
+	}
+	mSnap, err := groupRef.Collection("members").Doc(uid).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errNotGroupMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read membership for %s: %w", uid, err)
+	}
+	if !mSnap.Exists() {
+		return nil, errNotGroupMember
+	}
+	return snap, nil
+}
+
+// groupIsDeleted reports whether the group has been soft deleted.
+func groupIsDeleted(snap *firestore.DocumentSnapshot) bool {
+	_, ok := snap.Data()["deleted_at"]
+	return ok
+}
+
+// statusForError maps the shared group errors onto HTTP status codes.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, errGroupNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotGroupOwner), errors.Is(err, errNotGroupMember):
+		return http.StatusForbidden
+	case errors.Is(err, errGroupDeleted):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 
 // callerUID returns the acting user ID.
 // Dev mode: if DEV_BYPASS_AUTH=1, use X-Dev-UID or DEV_DEFAULT_UID.
@@ -41,41 +113,62 @@ var firestoreClient *firestore.Client
 // }
 
 
-// Handler
+// handler adapts one of the Group handlers to the mux. They write their
+// own error responses, so the returned error is only logged.
+func handler(h func(context.Context, http.ResponseWriter, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(r.Context(), w, r); err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+}
+
+// newRouter maps every Group endpoint by method and path. Anything else
+// gets a 404, or a 405 if only the method is wrong.
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Groups
+	mux.HandleFunc("POST /groups", func(w http.ResponseWriter, r *http.Request) {
+		createGroupHandler(r.Context(), w, r)
+	})
+	mux.HandleFunc("DELETE /groups/{groupId}", handler(deleteGroupHandler))
+	mux.HandleFunc("POST /groups/{groupId}/restore", handler(restoreGroupHandler))
+	mux.HandleFunc("POST /groups/{groupId}/purge", handler(purgeGroupHandler))
+	mux.HandleFunc("GET /groups/{groupId}/settings", handler(getGroupSettings))
+	mux.HandleFunc("POST /groups/{groupId}/settings", handler(updateGroupSettings))
+	mux.HandleFunc("GET /groups/{groupId}/activity", handler(activityHandler))
+
+	// Invites
+	mux.HandleFunc("POST /groups/{groupId}/invites", handler(invite))
+	mux.HandleFunc("GET /groups/{groupId}/invites", handler(groupInvitesHandler))
+	mux.HandleFunc("GET /invites", handler(myInvitesHandler))
+	mux.HandleFunc("POST /invites/{groupId}/accept", handler(acceptGroupInvite))
+	mux.HandleFunc("POST /invites/{groupId}/decline", handler(declineGroupInvite))
+	mux.HandleFunc("POST /invites/token/{token}/accept", handler(acceptGroupInvite))
+
+	// Join codes
+	mux.HandleFunc("POST /groups/{groupId}/join-codes", handler(createJoinCode))
+	mux.HandleFunc("DELETE /groups/{groupId}/join-codes/{code}", handler(revokeJoinCode))
+	mux.HandleFunc("POST /join-codes/{code}/redeem", handler(redeemJoinCodeHandler))
+
+	// Blocks
+	mux.HandleFunc("GET /blocks", handler(listBlocks))
+	mux.HandleFunc("POST /blocks", handler(blockHandler))
+	mux.HandleFunc("DELETE /blocks", handler(blockHandler))
+
+	// Scheduled jobs
+	mux.HandleFunc("POST /jobs/purge-expired", handler(purgeExpiredGroupsHandler))
+	mux.HandleFunc("POST /jobs/dispatch-notifications", handler(dispatchNotificationsHandler))
+
+	return mux
+}
+
+var router = newRouter()
+
+// GroupHandler is the function entry point; see README.md for the routes.
 func GroupHandler(w http.ResponseWriter, r *http.Request) {
-    ctx := context.Background()
-	// Extract the type of ID and the actual ID from the URL path
-	pathSegments := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
-
-    // /group/
-    // /group/entries
-    // /group/status
-    // /group/accept
-    // /group/invite
-
-    log.Print(len(pathSegments))
-	log.Print(pathSegments)
-	editType := pathSegments[0]
-	if editType != "" {
-
-        
-        log.Printf("The edit type is %s", editType)
-
-        switch{
-			case editType == "invite":
-				invite(ctx, w, r)
-			case editType == "accept":
-				acceptGroupInvite(ctx, w, r)
-			default:
-                http.Error(w, "Invalid resource. Refer to README.md for valid resources", http.StatusBadRequest)
-
-        }
-		return
-	} else if editType == "" {  // check if we just have /group
-        createGroupHandler(ctx, w, r)
-    } else {
-        http.Error(w, "Invalid endpoint. Expected format: /group or /group/{group_feature}", http.StatusBadRequest)
-    }
+	router.ServeHTTP(w, r)
 }
 
 // Initialize Firestore client and HTTP handler
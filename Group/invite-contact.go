@@ -0,0 +1,106 @@
+package group
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	Invites for people who don't have an account yet.
+
+	These can't go in users/{uid}/group_invites because there is no uid, so
+	they are parked in the top level invites collection, keyed by group and
+	contact:
+
+	/invites/{inviteId}
+		group_id, contact, contact_type ("email" | "phone"),
+		status ("pending" | "claimed" | "expired"), sent_from, sent_at, expires_at,
+		token (see invite-links.go)
+
+	When an account is created with a matching email or phone number the
+	ClaimInvites function copies the invite into users/{uid}/group_invites
+	and marks this doc claimed.
+*/
+
+const inviteTTL = 7 * 24 * time.Hour
+
+// normalizeContact validates an email or phone number and puts it in the
+// form the users collection and the ClaimInvites function look it up by.
+func normalizeContact(email string, phone string) (string, string, error) {
+	if email != "" {
+		addr, err := mail.ParseAddress(strings.TrimSpace(email))
+		if err != nil || addr.Address != strings.TrimSpace(email) {
+			return "", "", fmt.Errorf("invalid email %q", email)
+		}
+		return "email", strings.ToLower(addr.Address), nil
+	}
+
+	// Keep the leading + and the digits, drop spaces, dashes, dots and parens
+	var b strings.Builder
+	for i, c := range strings.TrimSpace(phone) {
+		switch {
+		case c == '+' && i == 0:
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", "", fmt.Errorf("invalid phone %q", phone)
+		}
+	}
+	normalized := b.String()
+	if !strings.HasPrefix(normalized, "+") || len(normalized) < 9 || len(normalized) > 16 {
+		return "", "", fmt.Errorf("invalid phone %q; use international format like +15551234567", phone)
+	}
+	return "phone", normalized, nil
+}
+
+// findUserByContact returns the uid of the account with this email or phone
+// number, or "" if there isn't one yet.
+func findUserByContact(ctx context.Context, contactType string, contact string) (string, error) {
+	field := "email"
+	if contactType == "phone" {
+		field = "phone_number"
+	}
+	docs, err := firestoreClient.Collection("users").Where(field, "==", contact).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return "", err
+	}
+	if len(docs) == 0 {
+		return "", nil
+	}
+	return docs[0].Ref.ID, nil
+}
+
+// contactInviteID is stable per group and contact so inviting the same
+// person twice refreshes the existing invite instead of adding another.
+func contactInviteID(groupID string, contact string) string {
+	sum := sha256.Sum256([]byte(contact))
+	return groupID + "_" + hex.EncodeToString(sum[:8])
+}
+
+// saveContactInvite stores an invite for someone without an account.
+func saveContactInvite(ctx context.Context, groupID string, contactType string, contact string, sentFrom string, token string) (string, error) {
+	inviteID := contactInviteID(groupID, contact)
+	_, err := firestoreClient.Collection("invites").Doc(inviteID).Set(ctx, map[string]interface{}{
+		"group_id":     groupID,
+		"contact":      contact,
+		"contact_type": contactType,
+		"status":       "pending",
+		"sent_from":    sentFrom,
+		"sent_at":      firestore.ServerTimestamp,
+		"expires_at":   time.Now().Add(inviteTTL),
+		"token":        token,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error saving invite to Firestore: %w", err)
+	}
+	return inviteID, nil
+}
@@ -5,7 +5,9 @@ import (
 	"log"
 	"net/http"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 	// "os"
 	// "strings"
 	"google.golang.org/grpc/status"
@@ -16,8 +18,10 @@ import (
 
 func invite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	var requestBody struct {
-		GroupID		string `json:"group_id"`
+		GroupID		string `json:"-"`		// from the path
 		Invitee		string `json:"invitee"`
+		Email		string `json:"email"`		// for people without an account yet
+		Phone		string `json:"phone"`		// for people without an account yet
 		UserID		string `json:"user_id"`
 	}
 
@@ -27,14 +31,104 @@ func invite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
 		return fmt.Errorf("error parsing request body: %v", err)
 	}
+	requestBody.GroupID = r.PathValue("groupId")
 
-	// Ensure the Invitee is provided
-	if requestBody.Invitee == "" {
-		log.Printf("Missing required field: invitee")
-		http.Error(w, "Missing required field: invitee", http.StatusBadRequest)
+	if requestBody.GroupID == "" || requestBody.UserID == "" {
+		http.Error(w, "Missing required field: user_id and group_id", http.StatusBadRequest)
+		return fmt.Errorf("user_id and group_id are required")
+	}
+
+	// Ensure exactly one of invitee, email or phone is provided
+	given := 0
+	for _, v := range []string{requestBody.Invitee, requestBody.Email, requestBody.Phone} {
+		if v != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		log.Printf("Invite needs exactly one of invitee, email or phone")
+		http.Error(w, "Provide exactly one of invitee, email or phone", http.StatusBadRequest)
 		return fmt.Errorf("invitee is required")
 	}
  
+	// Only members can invite, and deleted groups can't take new members
+	groupSnap, err := requireGroupMember(ctx, requestBody.GroupID, requestBody.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send invite: %v", err), statusForError(err))
+		return err
+	}
+	if groupIsDeleted(groupSnap) {
+		http.Error(w, fmt.Sprintf("Group %s has been deleted", requestBody.GroupID), http.StatusGone)
+		return errGroupDeleted
+	}
+
+	// Per inviter and per group rate limits
+	retryAfter, err := checkInviteRateLimits(ctx, requestBody.UserID, requestBody.GroupID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send invite: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if retryAfter > 0 {
+		writeRateLimited(w, retryAfter, "Too many invites; try again later")
+		return fmt.Errorf("invite rate limit hit for %s in group %s", requestBody.UserID, requestBody.GroupID)
+	}
+
+	// Every invite gets a token for its deep link
+	token, err := newInviteToken()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create invite: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	deepLink, webLink := inviteLinks("token", token)
+
+	// Resolve an email / phone to an account, or park the invite until
+	// someone signs up with it
+	var contactType, contact string
+	if requestBody.Invitee == "" {
+		contactType, contact, err = normalizeContact(requestBody.Email, requestBody.Phone)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+
+		uid, err := findUserByContact(ctx, contactType, contact)
+		if err != nil {
+			log.Printf("Failed to look up %s %s: %v", contactType, contact, err)
+			http.Error(w, fmt.Sprintf("Failed to query Firestore: %v", err), http.StatusInternalServerError)
+			return err
+		}
+
+		if uid == "" {
+			target := firestoreClient.Collection("invites").Doc(contactInviteID(requestBody.GroupID, contact))
+			if err := checkOutstandingInvites(ctx, requestBody.GroupID, target); err != nil {
+				return writeInviteLimitError(w, err)
+			}
+			inviteID, err := saveContactInvite(ctx, requestBody.GroupID, contactType, contact, requestBody.UserID, token)
+			if err != nil {
+				log.Printf("Failed to save invite: %v", err)
+				http.Error(w, fmt.Sprintf("Failed to save invite: %v", err), http.StatusInternalServerError)
+				return err
+			}
+			if err := sendInviteMessage(ctx, requestBody.GroupID, contactType, contact, requestBody.UserID, token); err != nil {
+				log.Printf("Failed to send invite message to %s: %v", contact, err)
+			}
+			// The email / phone stays out of the feed; the whole group sees it
+			recordActivity(ctx, requestBody.GroupID, "invite_sent", requestBody.UserID, map[string]interface{}{
+				"contact_type": contactType,
+			})
+			writeJSON(w, map[string]string{
+				"message":   "Invite saved; it will show up once they create an account",
+				"group_id":  requestBody.GroupID,
+				"invite_id": inviteID,
+				"token":     token,
+				"deep_link": deepLink,
+				"web_link":  webLink,
+			})
+			return nil
+		}
+		requestBody.Invitee = uid
+	}
+
 	// Check if the Invitee is already in the group contest
 	groupRef := firestoreClient.Collection("groups").Doc(requestBody.GroupID).Collection("members")
 	query := groupRef.Where("user_id", "==", requestBody.Invitee).Limit(1)
@@ -56,6 +150,18 @@ func invite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	// Invitee isn't in the group, proceed with the invite
 	inviteRef := firestoreClient.Collection("users").Doc(requestBody.Invitee).Collection("group_invites").Doc(requestBody.GroupID)
 
+	// Respect the invitee's blocks and the group's invite cap
+	blocked, err := isInviteBlocked(ctx, requestBody.Invitee, requestBody.GroupID, requestBody.UserID)
+	if err == nil && blocked {
+		err = errInviteBlocked
+	}
+	if err == nil {
+		err = checkOutstandingInvites(ctx, requestBody.GroupID, inviteRef)
+	}
+	if err != nil {
+		return writeInviteLimitError(w, err)
+	}
+
 	// Save the invitation
 	log.Printf("Saving invite for user %s to group %s", requestBody.Invitee, requestBody.GroupID)
 	_, err = inviteRef.Set(ctx, map[string]interface{}{
@@ -63,6 +169,8 @@ func invite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		"status":		"pending",
 		"sent_at":		firestore.ServerTimestamp,
 		"sent_from":	requestBody.UserID,
+		"expires_at":	time.Now().Add(inviteTTL),
+		"token":		token,
 	})
 
 	if err != nil {
@@ -71,22 +179,48 @@ func invite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("error saving invite to Firestore: %v", err)
 	}
 
+	// Invited by email / phone: let them know outside the app too
+	if contact != "" {
+		if err := sendInviteMessage(ctx, requestBody.GroupID, contactType, contact, requestBody.UserID, token); err != nil {
+			log.Printf("Failed to send invite message to %s: %v", contact, err)
+		}
+	}
+
+	recordActivity(ctx, requestBody.GroupID, "invite_sent", requestBody.UserID, map[string]interface{}{
+		"member":		requestBody.Invitee,
+		"contact_type":	contactType,
+	})
+
 	// Respond with success
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"message":  "Invite sent successfully",
-		"group_id": requestBody.GroupID,
+		"message":   "Invite sent successfully",
+		"group_id":  requestBody.GroupID,
+		"token":     token,
+		"deep_link": deepLink,
+		"web_link":  webLink,
 	})
 
 	return nil
 }
 
 
+// acceptGroupInvite joins the group the invite is for.
+//
+//	POST /invites/{groupId}/accept {"user_id"}
+//	POST /invites/token/{token}/accept {"user_id"}, from an invite link
 func acceptGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed; only POST is supported", http.StatusMethodNotAllowed)
-		return fmt.Errorf("method not allowed")
-	}
+	return respondToGroupInvite(ctx, w, r, true)
+}
+
+// declineGroupInvite drops the invite.
+//
+//	POST /invites/{groupId}/decline {"user_id"}
+func declineGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return respondToGroupInvite(ctx, w, r, false)
+}
+
+func respondToGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Request, accepted bool) error {
 	// uid, err := callerUID(r.Context(), r)
 	// if err != nil {
 	// 	http.Error(w, "unauthorized", http.StatusUnauthorized)
@@ -95,8 +229,8 @@ func acceptGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 	type reqBody struct {
 		UserID   string `json:"user_id"`
-		GroupID  string `json:"group_id"`
-		Accepted bool   `json:"accepted"`
+		GroupID  string `json:"-"` // from the path
+		Accepted bool   `json:"-"` // from the route
 	}
 
 	// Prefer the request context for cancellation/timeouts
@@ -111,16 +245,30 @@ func acceptGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Reque
 		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
 		return err
 	}
-	if req.UserID == "" || req.GroupID == "" {
-		http.Error(w, "user_id and group_id are required", http.StatusBadRequest)
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
 		return fmt.Errorf("missing required fields")
 	}
 
+	// The path says which invite: a group ID or the token of an invite link
+	req.Accepted = accepted
+	req.GroupID = r.PathValue("groupId")
+
+	if token := r.PathValue("token"); token != "" {
+		groupID, err := resolveInviteToken(ctx, req.UserID, token)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if errors.Is(err, errInviteNotFound) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, fmt.Sprintf("Failed to accept invite: %v", err), code)
+			return err
+		}
+		req.GroupID = groupID
+	}
+
 	inviteRef := firestoreClient.Collection("users").Doc(req.UserID).Collection("group_invites").Doc(req.GroupID)
-	groupRef := firestoreClient.Collection("groups").Doc(req.GroupID)
-	memberRef := groupRef.Collection("members").Doc(req.UserID)
-	myGroupsRef := firestoreClient.Collection("users").Doc(req.UserID).Collection("my_groups").Doc(req.GroupID)
-	userRef := firestoreClient.Collection("users").Doc(req.UserID)
+	memberRef := firestoreClient.Collection("groups").Doc(req.GroupID).Collection("members").Doc(req.UserID)
 
 	// Decline: just delete the invite and return.
 	if !req.Accepted {
@@ -138,9 +286,13 @@ func acceptGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	}
 
 	// Accept path: do it atomically and idempotently.
+	var join memberJoin
 	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-		// 1) Verify invite exists
-		if _, err := tx.Get(inviteRef); err != nil {
+		join = memberJoin{}
+
+		// 1) Verify invite exists and hasn't expired
+		iSnap, err := tx.Get(inviteRef)
+		if err != nil {
 			if status.Code(err) == codes.NotFound {
 				// If invite missing but member already exists, treat as already accepted
 				if mSnap, merr := tx.Get(memberRef); merr == nil && mSnap.Exists() {
@@ -150,67 +302,36 @@ func acceptGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Reque
 			}
 			return fmt.Errorf("failed reading invite: %w", err)
 		}
-
-		// 2) Fetch user_name (fallback to user_id)
-		userName := req.UserID
-		if uSnap, err := tx.Get(userRef); err == nil {
-			if n, err := uSnap.DataAt("user_name"); err == nil {
-				if s, ok := n.(string); ok && s != "" {
-					userName = s
-				}
-			}
+		if exp, ok := iSnap.Data()["expires_at"].(time.Time); ok && time.Now().After(exp) {
+			return fmt.Errorf("invite for user %s in group %s has expired", req.UserID, req.GroupID)
 		}
-
-		// 3) If already a member, just ensure mirrors are in place and delete invite
-		if mSnap, err := tx.Get(memberRef); err == nil && mSnap.Exists() {
-			_ = tx.Delete(inviteRef)
-			if err := tx.Set(myGroupsRef, map[string]interface{}{
-				"group_id":  "/groups/" + req.GroupID,
-				"timestamp": firestore.ServerTimestamp,
-			}, firestore.MergeAll); err != nil {
-				return fmt.Errorf("failed to upsert my_groups: %w", err)
-			}
-			return nil
+		addedBy, _ := iSnap.Data()["sent_from"].(string)
+		if addedBy == "" {
+			addedBy = req.UserID
 		}
 
-		// 4) Add the member
-		if err := tx.Set(memberRef, map[string]interface{}{
-			"user_id":   req.UserID,
-			"user_name": userName,
-			"joined_at": firestore.ServerTimestamp,
-			"added_by":  req.UserID, // or inviter if you track it
-		}, firestore.MergeAll); err != nil {
-			return fmt.Errorf("failed to add member: %w", err)
+		// 2) Add the member, or just fix up the mirrors if they already are one
+		if join, err = addMemberTx(tx, req.GroupID, req.UserID, addedBy); err != nil {
+			return err
 		}
 
-		// 5) (Optional) increment a counter if you keep one
-		// if err := tx.Update(groupRef, []firestore.Update{
-		// 	{Path: "member_count", Value: firestore.Increment(1)},
-		// }); err != nil {
-		// 	return fmt.Errorf("failed to increment member_count: %w", err)
-		// }
-
-		// 6) Delete the invite (idempotent)
+		// 3) Delete the invite (idempotent)
 		if err := tx.Delete(inviteRef); err != nil && status.Code(err) != codes.NotFound {
 			return fmt.Errorf("failed to delete invite: %w", err)
 		}
-
-		// 7) Mirror in user's my_groups (idempotent)
-		if err := tx.Set(myGroupsRef, map[string]interface{}{
-			"group_id":  "/groups/" + req.GroupID,
-			"timestamp": firestore.ServerTimestamp,
-		}, firestore.MergeAll); err != nil {
-			return fmt.Errorf("failed to upsert my_groups: %w", err)
-		}
-
-
 		return nil
 	})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to accept invite: %v", err), http.StatusBadRequest)
+		code := http.StatusBadRequest
+		if s := statusForError(err); s != http.StatusInternalServerError {
+			code = s
+		}
+		http.Error(w, fmt.Sprintf("Failed to accept invite: %v", err), code)
 		return err
 	}
 
+	notifyOwnerOfJoin(ctx, req.GroupID, req.UserID, join)
+
 	writeJSON(w, map[string]string{
 		"message":  fmt.Sprintf("User %s accepted group %s", req.UserID, req.GroupID),
 		"group_id": req.GroupID,
@@ -218,6 +339,97 @@ func acceptGroupInvite(ctx context.Context, w http.ResponseWriter, r *http.Reque
 	return nil
 }
 
+// memberJoin is what addMemberTx found while adding someone to a group.
+type memberJoin struct {
+	Joined   bool // false if they were already a member
+	OwnerID  string
+	UserName string
+}
+
+// addMemberTx adds uid to the group and mirrors it into their my_groups.
+// It is shared by accepting an invite and redeeming a join code. All of its
+// reads come before its writes, so callers have to do their own reads first.
+func addMemberTx(tx *firestore.Transaction, groupID string, uid string, addedBy string) (memberJoin, error) {
+	var join memberJoin
+	groupRef := firestoreClient.Collection("groups").Doc(groupID)
+	memberRef := groupRef.Collection("members").Doc(uid)
+	userRef := firestoreClient.Collection("users").Doc(uid)
+	myGroupsRef := userRef.Collection("my_groups").Doc(groupID)
+
+	// Deleted groups can't take new members
+	gSnap, err := tx.Get(groupRef)
+	if status.Code(err) == codes.NotFound {
+		return join, errGroupNotFound
+	}
+	if err != nil {
+		return join, fmt.Errorf("failed to read group %s: %w", groupID, err)
+	}
+	if groupIsDeleted(gSnap) {
+		return join, errGroupDeleted
+	}
+	join.OwnerID, _ = gSnap.Data()["created_by"].(string)
+
+	// Fetch user_name (fallback to user_id)
+	join.UserName = uid
+	if uSnap, err := tx.Get(userRef); err == nil {
+		if n, _ := uSnap.Data()["user_name"].(string); n != "" {
+			join.UserName = n
+		}
+	}
+
+	mSnap, err := tx.Get(memberRef)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return join, fmt.Errorf("failed to read membership for %s: %w", uid, err)
+	}
+	alreadyMember := err == nil && mSnap.Exists()
+
+	if !alreadyMember {
+		if err := tx.Set(memberRef, map[string]interface{}{
+			"user_id":   uid,
+			"user_name": join.UserName,
+			"joined_at": firestore.ServerTimestamp,
+			"added_by":  addedBy,
+		}, firestore.MergeAll); err != nil {
+			return join, fmt.Errorf("failed to add member: %w", err)
+		}
+		// stats.member_count is kept up to date by the GroupStats triggers
+		if err := tx.Create(groupRef.Collection("activity").NewDoc(), activityDoc("member_joined", uid, map[string]interface{}{
+			"member":   uid,
+			"added_by": addedBy,
+		})); err != nil {
+			return join, fmt.Errorf("failed to record join: %w", err)
+		}
+	}
+
+	// Mirror in user's my_groups (idempotent)
+	if err := tx.Set(myGroupsRef, map[string]interface{}{
+		"group_id":  "/groups/" + groupID,
+		"timestamp": firestore.ServerTimestamp,
+	}, firestore.MergeAll); err != nil {
+		return join, fmt.Errorf("failed to upsert my_groups: %w", err)
+	}
+
+	join.Joined = !alreadyMember
+	return join, nil
+}
+
+// notifyOwnerOfJoin lets the owner know someone joined; held back until
+// quiet hours end.
+func notifyOwnerOfJoin(ctx context.Context, groupID string, uid string, join memberJoin) {
+	if !join.Joined || join.OwnerID == "" || join.OwnerID == uid {
+		return
+	}
+	if _, _, err := queueNotification(ctx, groupID, notification{
+		Type:    "member_joined",
+		Channel: "in_app",
+		To:      join.OwnerID,
+		Title:   "New roommate",
+		Body:    fmt.Sprintf("%s joined your group", join.UserName),
+	}); err != nil {
+		log.Printf("Failed to queue member_joined notification for group %s: %v", groupID, err)
+	}
+}
+
 // helpers
 
 func writeJSON(w http.ResponseWriter, v any) {
@@ -0,0 +1,236 @@
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Limits on who can send invites and how many.
+
+	- each inviter can send invitesPerInviter per hour, and each group
+	  invitesPerGroup per day (rate_limits/{key}, see rate-limit.go)
+	- a group can have at most maxOutstandingInvites pending invites;
+	  re-inviting someone who already has one just refreshes it
+	- users can block a group or an inviter:
+
+	/users/{uid}/blocked/{group_<groupId> | user_<uid>}
+		type ("group" | "user"), id, blocked_at
+*/
+
+const (
+	invitesPerInviter     = 20
+	inviterWindow         = time.Hour
+	invitesPerGroup       = 50
+	groupInviteWindow     = 24 * time.Hour
+	maxOutstandingInvites = 25
+)
+
+var (
+	errInviteBlocked   = errors.New("this user isn't accepting invites from you or this group")
+	errTooManyInvites  = errors.New("too many outstanding invites for this group")
+	errInvalidBlockReq = errors.New("provide exactly one of group_id or inviter_id")
+)
+
+func blockedDocID(kind string, id string) string {
+	return kind + "_" + id
+}
+
+// checkInviteRateLimits counts one invite against the inviter and the
+// group. It returns how long to wait if either is over its limit.
+func checkInviteRateLimits(ctx context.Context, inviterID string, groupID string) (time.Duration, error) {
+	wait, err := takeRateLimit(ctx, "invite_user_"+inviterID, invitesPerInviter, inviterWindow)
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	return takeRateLimit(ctx, "invite_group_"+groupID, invitesPerGroup, groupInviteWindow)
+}
+
+// countQuery runs a count aggregation.
+func countQuery(ctx context.Context, q firestore.Query) (int64, error) {
+	res, err := q.NewAggregationQuery().WithCount("n").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := res["n"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", res["n"])
+	}
+	return v.GetIntegerValue(), nil
+}
+
+// checkOutstandingInvites fails with errTooManyInvites if the group is at
+// its cap. target is the invite about to be written; if it already exists
+// the invite is only being refreshed and doesn't count.
+func checkOutstandingInvites(ctx context.Context, groupID string, target *firestore.DocumentRef) error {
+	if _, err := target.Get(ctx); err == nil {
+		return nil
+	} else if status.Code(err) != codes.NotFound {
+		return err
+	}
+
+	now := time.Now()
+	toAccounts, err := countQuery(ctx, firestoreClient.CollectionGroup("group_invites").
+		Where("group_id", "==", groupID).
+		Where("expires_at", ">", now))
+	if err != nil {
+		return fmt.Errorf("failed to count invites: %w", err)
+	}
+	toContacts, err := countQuery(ctx, firestoreClient.Collection("invites").
+		Where("group_id", "==", groupID).
+		Where("status", "==", "pending").
+		Where("expires_at", ">", now))
+	if err != nil {
+		return fmt.Errorf("failed to count invites: %w", err)
+	}
+	if toAccounts+toContacts >= maxOutstandingInvites {
+		return errTooManyInvites
+	}
+	return nil
+}
+
+// isInviteBlocked reports whether uid has blocked the group or the inviter.
+func isInviteBlocked(ctx context.Context, uid string, groupID string, inviterID string) (bool, error) {
+	blocked := firestoreClient.Collection("users").Doc(uid).Collection("blocked")
+	snaps, err := firestoreClient.GetAll(ctx, []*firestore.DocumentRef{
+		blocked.Doc(blockedDocID("group", groupID)),
+		blocked.Doc(blockedDocID("user", inviterID)),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read blocks for %s: %w", uid, err)
+	}
+	for _, snap := range snaps {
+		if snap.Exists() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// blockHandler adds (POST) or removes (DELETE) one of the caller's blocks.
+// It takes exactly one of group_id or inviter_id.
+func blockHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID    string `json:"user_id"`
+		GroupID   string `json:"group_id"`
+		InviterID string `json:"inviter_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	if (req.GroupID == "") == (req.InviterID == "") {
+		http.Error(w, errInvalidBlockReq.Error(), http.StatusBadRequest)
+		return errInvalidBlockReq
+	}
+
+	kind, id := "group", req.GroupID
+	if req.InviterID != "" {
+		kind, id = "user", req.InviterID
+	}
+	if kind == "user" && id == req.UserID {
+		http.Error(w, "You can't block yourself", http.StatusBadRequest)
+		return fmt.Errorf("user %s tried to block themselves", req.UserID)
+	}
+	userRef := firestoreClient.Collection("users").Doc(req.UserID)
+	blockRef := userRef.Collection("blocked").Doc(blockedDocID(kind, id))
+
+	if r.Method == http.MethodDelete {
+		if _, err := blockRef.Delete(ctx); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to unblock: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		writeJSON(w, map[string]string{
+			"message": fmt.Sprintf("Unblocked %s %s", kind, id),
+		})
+		return nil
+	}
+
+	if _, err := blockRef.Set(ctx, map[string]interface{}{
+		"type":       kind,
+		"id":         id,
+		"blocked_at": firestore.ServerTimestamp,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to block: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	// Drop pending invites from whoever was just blocked
+	invites := userRef.Collection("group_invites")
+	var stale []*firestore.DocumentRef
+	if kind == "group" {
+		stale = append(stale, invites.Doc(id))
+	} else {
+		docs, err := invites.Where("sent_from", "==", id).Documents(ctx).GetAll()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to clear invites: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		for _, doc := range docs {
+			stale = append(stale, doc.Ref)
+		}
+	}
+	for _, ref := range stale {
+		if _, err := ref.Delete(ctx); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to clear invites: %v", err), http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("Blocked %s %s", kind, id),
+	})
+	return nil
+}
+
+// listBlocks returns everything the caller has blocked.
+func listBlocks(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	docs, err := firestoreClient.Collection("users").Doc(userID).Collection("blocked").Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list blocks: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	blocks := make([]map[string]string, 0, len(docs))
+	for _, doc := range docs {
+		kind, _ := doc.Data()["type"].(string)
+		id, _ := doc.Data()["id"].(string)
+		blocks = append(blocks, map[string]string{"type": kind, "id": id})
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"blocked": blocks,
+	})
+	return nil
+}
+
+// writeInviteLimitError answers with the status for a block / cap error.
+func writeInviteLimitError(w http.ResponseWriter, err error) error {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errInviteBlocked):
+		code = http.StatusForbidden
+	case errors.Is(err, errTooManyInvites):
+		code = http.StatusConflict
+	}
+	http.Error(w, fmt.Sprintf("Failed to send invite: %v", err), code)
+	return err
+}
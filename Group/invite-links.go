@@ -0,0 +1,121 @@
+package group
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+/*
+	Invite links.
+
+	Every invite gets a random token that goes in its links:
+
+	myapp://invite?token=...                            (INVITE_LINK_BASE)
+	https://roommates-473217.web.app/invite?token=...   (INVITE_WEB_BASE)
+
+	The web link is the fallback for people without the app; it should
+	redirect into the app once it's installed. Join codes use the same
+	links with code=... instead of token=...
+*/
+
+var errInviteNotFound = errors.New("invite not found or no longer valid")
+
+// newInviteToken returns a random URL safe token.
+func newInviteToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate invite token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// inviteLinks builds the app deep link and the web fallback for an invite
+// token (param "token") or join code (param "code").
+func inviteLinks(param string, value string) (string, string) {
+	deepBase := os.Getenv("INVITE_LINK_BASE")
+	if deepBase == "" {
+		deepBase = "myapp://invite"
+	}
+	webBase := os.Getenv("INVITE_WEB_BASE")
+	if webBase == "" {
+		webBase = "https://roommates-473217.web.app/invite"
+	}
+	query := "?" + param + "=" + url.QueryEscape(value)
+	return deepBase + query, webBase + query
+}
+
+// resolveInviteToken finds the group an invite token is for on behalf of
+// uid. Tokens from invites sent straight to uid are looked up in their
+// group_invites. Tokens from email / phone invites that nobody has claimed
+// yet are claimed for uid first, since whoever got the message may have
+// signed up with a different address.
+func resolveInviteToken(ctx context.Context, uid string, token string) (string, error) {
+	userInvites := firestoreClient.Collection("users").Doc(uid).Collection("group_invites")
+	docs, err := userInvites.Where("token", "==", token).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to look up invite token: %w", err)
+	}
+	if len(docs) > 0 {
+		return docs[0].Ref.ID, nil
+	}
+
+	docs, err = firestoreClient.Collection("invites").
+		Where("token", "==", token).
+		Where("status", "==", "pending").
+		Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		return "", fmt.Errorf("failed to look up invite token: %w", err)
+	}
+	if len(docs) == 0 {
+		return "", errInviteNotFound
+	}
+
+	var groupID string
+	inviteRef := docs[0].Ref
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(inviteRef)
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		if s, _ := data["status"].(string); s != "pending" {
+			return errInviteNotFound
+		}
+		if exp, ok := data["expires_at"].(time.Time); ok && time.Now().After(exp) {
+			return errInviteNotFound
+		}
+		groupID, _ = data["group_id"].(string)
+		if groupID == "" {
+			return fmt.Errorf("invite %s has no group_id", inviteRef.ID)
+		}
+
+		if err := tx.Set(userInvites.Doc(groupID), map[string]interface{}{
+			"group_id":   groupID,
+			"status":     "pending",
+			"sent_at":    data["sent_at"],
+			"sent_from":  data["sent_from"],
+			"expires_at": data["expires_at"],
+			"invite_id":  inviteRef.ID,
+			"token":      token,
+		}); err != nil {
+			return err
+		}
+		return tx.Update(inviteRef, []firestore.Update{
+			{Path: "status", Value: "claimed"},
+			{Path: "claimed_by", Value: uid},
+			{Path: "claimed_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return groupID, nil
+}
@@ -0,0 +1,228 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// inviteView is one invite as the app shows it.
+type inviteView struct {
+	GroupID     string `json:"group_id"`
+	GroupName   string `json:"group_name,omitempty"`
+	Invitee     string `json:"invitee,omitempty"`      // uid, for invites to an account
+	InviteeName string `json:"invitee_name,omitempty"` // user_name for invitee
+	Contact     string `json:"contact,omitempty"`      // email / phone, for invites not claimed yet
+	ContactType string `json:"contact_type,omitempty"`
+	SentFrom    string `json:"sent_from,omitempty"`
+	InviterName string `json:"inviter_name,omitempty"`
+	Status      string `json:"status"` // "pending" or "expired"
+	SentAt      string `json:"sent_at,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
+
+// inviteViewFromData fills in what an invite doc says about itself.
+// Invites that are past expires_at report status "expired".
+func inviteViewFromData(data map[string]interface{}, now time.Time) inviteView {
+	v := inviteView{Status: "pending"}
+	v.GroupID, _ = data["group_id"].(string)
+	v.SentFrom, _ = data["sent_from"].(string)
+	if s, _ := data["status"].(string); s != "" {
+		v.Status = s
+	}
+	if t, ok := data["sent_at"].(time.Time); ok {
+		v.SentAt = t.UTC().Format(time.RFC3339)
+	}
+	if t, ok := data["expires_at"].(time.Time); ok {
+		v.ExpiresAt = t.UTC().Format(time.RFC3339)
+		if v.Status == "pending" && now.After(t) {
+			v.Status = "expired"
+		}
+	}
+	return v
+}
+
+// lookupNames reads the given docs in one round trip and returns their
+// nameField by doc ID. Missing docs are left out.
+func lookupNames(ctx context.Context, col *firestore.CollectionRef, ids []string, nameField string) (map[string]string, error) {
+	names := make(map[string]string)
+	seen := make(map[string]bool)
+	var refs []*firestore.DocumentRef
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		refs = append(refs, col.Doc(id))
+	}
+	if len(refs) == 0 {
+		return names, nil
+	}
+
+	snaps, err := firestoreClient.GetAll(ctx, refs)
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		if name, _ := snap.Data()[nameField].(string); name != "" {
+			names[snap.Ref.ID] = name
+		}
+	}
+	return names, nil
+}
+
+// myInvitesHandler lists the caller's invites with group and inviter names,
+// newest first. Invites to groups that have been deleted are left out.
+//
+//	GET /invites?user_id=...
+func myInvitesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	docs, err := firestoreClient.Collection("users").Doc(userID).Collection("group_invites").Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list invites: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	now := time.Now()
+	invites := make([]inviteView, 0, len(docs))
+	var groupIDs, inviterIDs []string
+	for _, doc := range docs {
+		v := inviteViewFromData(doc.Data(), now)
+		if v.GroupID == "" {
+			v.GroupID = doc.Ref.ID
+		}
+		invites = append(invites, v)
+		groupIDs = append(groupIDs, v.GroupID)
+		inviterIDs = append(inviterIDs, v.SentFrom)
+	}
+
+	// Resolve names, dropping invites whose group is gone or deleted
+	groupRefs := make([]*firestore.DocumentRef, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		groupRefs = append(groupRefs, firestoreClient.Collection("groups").Doc(id))
+	}
+	groupNames := make(map[string]string)
+	liveGroups := make(map[string]bool)
+	if len(groupRefs) > 0 {
+		snaps, err := firestoreClient.GetAll(ctx, groupRefs)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read groups: %v", err), http.StatusInternalServerError)
+			return err
+		}
+		for _, snap := range snaps {
+			if !snap.Exists() || groupIsDeleted(snap) {
+				continue
+			}
+			liveGroups[snap.Ref.ID] = true
+			groupNames[snap.Ref.ID], _ = snap.Data()["group_name"].(string)
+		}
+	}
+	inviterNames, err := lookupNames(ctx, firestoreClient.Collection("users"), inviterIDs, "user_name")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read inviters: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	result := invites[:0]
+	for _, v := range invites {
+		if !liveGroups[v.GroupID] {
+			continue
+		}
+		v.Invitee = userID
+		v.GroupName = groupNames[v.GroupID]
+		v.InviterName = inviterNames[v.SentFrom]
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].SentAt > result[j].SentAt })
+
+	writeJSON(w, map[string]interface{}{
+		"invites": result,
+	})
+	return nil
+}
+
+// groupInvitesHandler lists every outstanding invite for a group, both the
+// ones sent to accounts and the ones still waiting on a sign up. Owner only.
+//
+//	GET /groups/{groupId}/invites?user_id=...
+func groupInvitesHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	groupID := r.PathValue("groupId")
+	if userID == "" || groupID == "" {
+		http.Error(w, "user_id and group_id are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	if _, err := requireGroupOwner(ctx, groupID, userID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list invites: %v", err), statusForError(err))
+		return err
+	}
+
+	now := time.Now()
+	var invites []inviteView
+	var userIDs []string
+
+	// Invites to accounts, users/{uid}/group_invites/{groupId}
+	docs, err := firestoreClient.CollectionGroup("group_invites").Where("group_id", "==", groupID).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list invites: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	for _, doc := range docs {
+		v := inviteViewFromData(doc.Data(), now)
+		v.Invitee = doc.Ref.Parent.Parent.ID
+		invites = append(invites, v)
+		userIDs = append(userIDs, v.Invitee, v.SentFrom)
+	}
+
+	// Invites to an email / phone nobody has signed up with yet. Claimed
+	// ones already showed up above.
+	docs, err = firestoreClient.Collection("invites").
+		Where("group_id", "==", groupID).
+		Where("status", "in", []string{"pending", "expired"}).
+		Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list invites: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	for _, doc := range docs {
+		data := doc.Data()
+		v := inviteViewFromData(data, now)
+		v.Contact, _ = data["contact"].(string)
+		v.ContactType, _ = data["contact_type"].(string)
+		invites = append(invites, v)
+		userIDs = append(userIDs, v.SentFrom)
+	}
+
+	names, err := lookupNames(ctx, firestoreClient.Collection("users"), userIDs, "user_name")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read users: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	for i := range invites {
+		invites[i].InviteeName = names[invites[i].Invitee]
+		invites[i].InviterName = names[invites[i].SentFrom]
+	}
+	sort.Slice(invites, func(i, j int) bool { return invites[i].SentAt > invites[j].SentAt })
+
+	if invites == nil {
+		invites = []inviteView{}
+	}
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"invites":  invites,
+	})
+	return nil
+}
@@ -0,0 +1,122 @@
+package group
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// inviteMessageData fills the invite templates.
+type inviteMessageData struct {
+	GroupName   string
+	InviterName string
+	DeepLink    string
+	WebLink     string
+	ExpiresAt   string
+}
+
+var (
+	inviteEmailSubject = template.Must(template.New("invite_email_subject").Parse(
+		`{{.InviterName}} invited you to join {{.GroupName}} on Roommates`))
+
+	inviteEmailBody = template.Must(template.New("invite_email_body").Parse(`Hi!
+
+{{.InviterName}} invited you to join {{.GroupName}} on Roommates, where you can share chores and keep the house running.
+
+Open this link on your phone to join:
+{{.DeepLink}}
+
+Don't have the app yet? Use this link instead:
+{{.WebLink}}
+
+The invite expires on {{.ExpiresAt}}. If you sign up with this email address the invite will be waiting for you.
+`))
+
+	inviteSMSBody = template.Must(template.New("invite_sms_body").Parse(
+		`{{.InviterName}} invited you to join {{.GroupName}} on Roommates: {{.WebLink}} (expires {{.ExpiresAt}})`))
+)
+
+func renderTemplate(t *template.Template, data inviteMessageData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render %s: %w", t.Name(), err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// displayName returns a user's user_name, or fallback if they don't have one.
+func displayName(ctx context.Context, uid string, fallback string) string {
+	if uid == "" {
+		return fallback
+	}
+	snap, err := firestoreClient.Collection("users").Doc(uid).Get(ctx)
+	if err != nil {
+		return fallback
+	}
+	if name, _ := snap.Data()["user_name"].(string); name != "" {
+		return name
+	}
+	return fallback
+}
+
+// groupDisplayName returns the group's group_name, or a generic fallback.
+func groupDisplayName(ctx context.Context, groupID string) string {
+	snap, err := firestoreClient.Collection("groups").Doc(groupID).Get(ctx)
+	if err != nil {
+		return "their group"
+	}
+	if name, _ := snap.Data()["group_name"].(string); name != "" {
+		return name
+	}
+	return "their group"
+}
+
+// sendInviteMessage queues an invite email or text for contact and sends it
+// straight away unless the group is in quiet hours. Anything that fails is
+// retried by the notification dispatcher.
+func sendInviteMessage(ctx context.Context, groupID string, contactType string, contact string, inviterID string, token string) error {
+	deepLink, webLink := inviteLinks("token", token)
+	data := inviteMessageData{
+		GroupName:   groupDisplayName(ctx, groupID),
+		InviterName: displayName(ctx, inviterID, "A roommate"),
+		DeepLink:    deepLink,
+		WebLink:     webLink,
+		ExpiresAt:   time.Now().Add(inviteTTL).Format("January 2, 2006"),
+	}
+
+	n := notification{Type: "group_invite", To: contact}
+	var err error
+	switch contactType {
+	case "email":
+		n.Channel = "email"
+		if n.Title, err = renderTemplate(inviteEmailSubject, data); err != nil {
+			return err
+		}
+		if n.Body, err = renderTemplate(inviteEmailBody, data); err != nil {
+			return err
+		}
+	case "phone":
+		n.Channel = "sms"
+		if n.Body, err = renderTemplate(inviteSMSBody, data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown contact type %q", contactType)
+	}
+
+	ref, deliverAfter, err := queueNotification(ctx, groupID, n)
+	if err != nil {
+		return err
+	}
+	if deliverAfter.After(time.Now()) {
+		return nil
+	}
+	if err := deliverNotification(ctx, ref); err != nil {
+		log.Printf("Invite message %s not delivered yet, the dispatcher will retry: %v", ref.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package group
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Shareable join codes, for posting in a group chat instead of inviting
+	people one by one.
+
+	/join_codes/{code}
+		group_id, created_by, created_at, expires_at, max_uses, uses, revoked
+
+	Codes are 6 characters from an alphabet without look-alikes (no 0/O or
+	1/I/L) so they are easy to type. Redeeming one adds the member through
+	the same addMemberTx as accepting an invite. Redemption is rate limited
+	per user and per client IP, since user_id is whatever the caller sends:
+	at 20 tries per 15 minutes an address would need years to hit a given
+	group's code among the ~900 million possible ones.
+*/
+
+const (
+	joinCodeLength         = 6
+	joinCodeAlphabet       = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	defaultJoinCodeMaxUses = 10
+	maxJoinCodeMaxUses     = 100
+	defaultJoinCodeHours   = 72
+	maxJoinCodeHours       = 30 * 24
+	joinCodeAttempts       = 5
+	joinCodeIPAttempts     = 20 // a dorm or office can share one address
+	joinCodeAttemptWindow  = 15 * time.Minute
+)
+
+var errJoinCodeInvalid = errors.New("join code is invalid, used up or expired")
+
+// newJoinCode returns a random code from joinCodeAlphabet.
+func newJoinCode() (string, error) {
+	var b strings.Builder
+	size := big.NewInt(int64(len(joinCodeAlphabet)))
+	for i := 0; i < joinCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, size)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate join code: %w", err)
+		}
+		b.WriteByte(joinCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// normalizeJoinCode upper cases a typed code and drops spaces and dashes.
+func normalizeJoinCode(code string) (string, bool) {
+	code = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	if len(code) != joinCodeLength {
+		return "", false
+	}
+	for _, c := range code {
+		if !strings.ContainsRune(joinCodeAlphabet, c) {
+			return "", false
+		}
+	}
+	return code, true
+}
+
+// createJoinCode makes a new join code for the group. Owner only.
+func createJoinCode(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID         string `json:"user_id"`
+		GroupID        string `json:"-"` // from the path
+		MaxUses        *int   `json:"max_uses"`
+		ExpiresInHours *int   `json:"expires_in_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.GroupID = r.PathValue("groupId")
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	maxUses := defaultJoinCodeMaxUses
+	if req.MaxUses != nil {
+		maxUses = *req.MaxUses
+	}
+	if maxUses < 1 || maxUses > maxJoinCodeMaxUses {
+		http.Error(w, fmt.Sprintf("max_uses must be between 1 and %d", maxJoinCodeMaxUses), http.StatusBadRequest)
+		return fmt.Errorf("invalid max_uses %d", maxUses)
+	}
+	hours := defaultJoinCodeHours
+	if req.ExpiresInHours != nil {
+		hours = *req.ExpiresInHours
+	}
+	if hours < 1 || hours > maxJoinCodeHours {
+		http.Error(w, fmt.Sprintf("expires_in_hours must be between 1 and %d", maxJoinCodeHours), http.StatusBadRequest)
+		return fmt.Errorf("invalid expires_in_hours %d", hours)
+	}
+
+	snap, err := requireGroupOwner(ctx, req.GroupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create join code: %v", err), statusForError(err))
+		return err
+	}
+	if groupIsDeleted(snap) {
+		http.Error(w, fmt.Sprintf("Group %s has been deleted", req.GroupID), http.StatusGone)
+		return errGroupDeleted
+	}
+
+	// Create fails if the code is taken, so just roll a new one
+	expiresAt := time.Now().Add(time.Duration(hours) * time.Hour)
+	var code string
+	for attempt := 0; attempt < 5; attempt++ {
+		if code, err = newJoinCode(); err != nil {
+			break
+		}
+		_, err = firestoreClient.Collection("join_codes").Doc(code).Create(ctx, map[string]interface{}{
+			"group_id":   req.GroupID,
+			"created_by": req.UserID,
+			"created_at": firestore.ServerTimestamp,
+			"expires_at": expiresAt,
+			"max_uses":   maxUses,
+			"uses":       0,
+			"revoked":    false,
+		})
+		if status.Code(err) != codes.AlreadyExists {
+			break
+		}
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create join code: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	deepLink, webLink := inviteLinks("code", code)
+	writeJSON(w, map[string]interface{}{
+		"code":       code,
+		"group_id":   req.GroupID,
+		"max_uses":   maxUses,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+		"deep_link":  deepLink,
+		"web_link":   webLink,
+	})
+	return nil
+}
+
+// revokeJoinCode stops a join code from working. Owner only.
+func revokeJoinCode(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID  string `json:"user_id"`
+		GroupID string `json:"-"` // from the path
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.GroupID = r.PathValue("groupId")
+	code, ok := normalizeJoinCode(r.PathValue("code"))
+	if req.UserID == "" || !ok {
+		http.Error(w, "user_id and a valid code are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	if _, err := requireGroupOwner(ctx, req.GroupID, req.UserID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke join code: %v", err), statusForError(err))
+		return err
+	}
+
+	ref := firestoreClient.Collection("join_codes").Doc(code)
+	snap, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound || (err == nil && snap.Data()["group_id"] != req.GroupID) {
+		http.Error(w, fmt.Sprintf("Join code %s not found for group %s", code, req.GroupID), http.StatusNotFound)
+		return errJoinCodeInvalid
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke join code: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if _, err := ref.Update(ctx, []firestore.Update{
+		{Path: "revoked", Value: true},
+		{Path: "revoked_at", Value: firestore.ServerTimestamp},
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to revoke join code: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]string{
+		"message":  fmt.Sprintf("Join code %s revoked", code),
+		"group_id": req.GroupID,
+	})
+	return nil
+}
+
+// redeemJoinCodeHandler adds the caller to the group a join code is for.
+func redeemJoinCodeHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		Code   string `json:"-"` // from the path
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.Code = r.PathValue("code")
+	if req.UserID == "" || req.Code == "" {
+		http.Error(w, "user_id and code are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+
+	// Count every attempt, good or bad, so codes can't be brute forced,
+	// against the client's address as well as the user_id it sent
+	retryAfter, err := takeRateLimit(ctx, "join_code_"+req.UserID, joinCodeAttempts, joinCodeAttemptWindow)
+	if err == nil && retryAfter == 0 {
+		if ip := clientIP(r); ip != "" {
+			retryAfter, err = takeRateLimit(ctx, "join_code_ip_"+ip, joinCodeIPAttempts, joinCodeAttemptWindow)
+		}
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to redeem join code: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if retryAfter > 0 {
+		writeRateLimited(w, retryAfter, "Too many join code attempts; try again later")
+		return fmt.Errorf("join code rate limit hit for %s", req.UserID)
+	}
+
+	code, ok := normalizeJoinCode(req.Code)
+	if !ok {
+		http.Error(w, errJoinCodeInvalid.Error(), http.StatusNotFound)
+		return errJoinCodeInvalid
+	}
+
+	codeRef := firestoreClient.Collection("join_codes").Doc(code)
+	var groupID string
+	var join memberJoin
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		join = memberJoin{}
+
+		snap, err := tx.Get(codeRef)
+		if status.Code(err) == codes.NotFound {
+			return errJoinCodeInvalid
+		}
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		groupID, _ = data["group_id"].(string)
+		createdBy, _ := data["created_by"].(string)
+		uses, _ := data["uses"].(int64)
+		maxUses, _ := data["max_uses"].(int64)
+		revoked, _ := data["revoked"].(bool)
+		exp, _ := data["expires_at"].(time.Time)
+		if groupID == "" || revoked || uses >= maxUses || time.Now().After(exp) {
+			return errJoinCodeInvalid
+		}
+
+		if join, err = addMemberTx(tx, groupID, req.UserID, createdBy); err != nil {
+			return err
+		}
+		// Rejoining doesn't use up the code
+		if !join.Joined {
+			return nil
+		}
+		return tx.Update(codeRef, []firestore.Update{
+			{Path: "uses", Value: firestore.Increment(1)},
+			{Path: "last_used_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		code := statusForError(err)
+		if errors.Is(err, errJoinCodeInvalid) {
+			code = http.StatusNotFound
+		}
+		http.Error(w, fmt.Sprintf("Failed to redeem join code: %v", err), code)
+		return err
+	}
+
+	log.Printf("User %s joined group %s with a join code", req.UserID, groupID)
+	notifyOwnerOfJoin(ctx, groupID, req.UserID, join)
+
+	writeJSON(w, map[string]string{
+		"message":  fmt.Sprintf("User %s joined group %s", req.UserID, groupID),
+		"group_id": groupID,
+	})
+	return nil
+}
@@ -0,0 +1,177 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// notification is a message waiting in groups/{groupId}/notifications.
+// deliver_after is pushed past the group's quiet hours, so whatever delivers
+// these (the app for in_app, the dispatcher below for email and sms) only
+// has to look at messages whose deliver_after has passed.
+type notification struct {
+	Type    string // e.g. "member_joined", "group_invite"
+	Channel string // "in_app", "email" or "sms"
+	To      string // recipient uid for in_app, otherwise an email / phone number
+	Title   string // used as the email subject
+	Body    string
+}
+
+const (
+	maxDeliveryAttempts   = 5
+	dispatchBatchSize     = 100
+	dispatchTimeBudget    = 45 * time.Second
+	deliveryRetryBaseWait = 2 * time.Minute
+)
+
+var errNotificationClaimed = errors.New("notification already picked up")
+
+// queueNotification stores n for the group, deferred by quiet hours.
+func queueNotification(ctx context.Context, groupID string, n notification) (*firestore.DocumentRef, time.Time, error) {
+	groupRef := firestoreClient.Collection("groups").Doc(groupID)
+	snap, err := groupRef.Get(ctx)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read group %s: %w", groupID, err)
+	}
+
+	now := time.Now()
+	deliverAfter := settingsFromSnapshot(snap).deferForQuietHours(now)
+
+	ref, _, err := groupRef.Collection("notifications").Add(ctx, map[string]interface{}{
+		"type":          n.Type,
+		"channel":       n.Channel,
+		"to":            n.To,
+		"title":         n.Title,
+		"body":          n.Body,
+		"status":        "queued",
+		"attempts":      0,
+		"deferred":      deliverAfter.After(now),
+		"deliver_after": deliverAfter,
+		"created_at":    firestore.ServerTimestamp,
+	})
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to queue notification: %w", err)
+	}
+
+	if deliverAfter.After(now) {
+		log.Printf("Notification %s for %s in group %s deferred until %s (quiet hours)", n.Type, n.To, groupID, deliverAfter.Format(time.RFC3339))
+	}
+	return ref, deliverAfter, nil
+}
+
+// deliverNotification sends one queued email / sms notification. Failures
+// are put back in the queue with an exponential backoff until
+// maxDeliveryAttempts is reached.
+func deliverNotification(ctx context.Context, ref *firestore.DocumentRef) error {
+	var n notification
+	var attempts int64
+
+	// Claim it so the dispatcher and an immediate send can't both deliver it
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		snap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		data := snap.Data()
+		if s, _ := data["status"].(string); s != "queued" {
+			return errNotificationClaimed
+		}
+		n.Type, _ = data["type"].(string)
+		n.Channel, _ = data["channel"].(string)
+		n.To, _ = data["to"].(string)
+		n.Title, _ = data["title"].(string)
+		n.Body, _ = data["body"].(string)
+		attempts, _ = data["attempts"].(int64)
+		return tx.Update(ref, []firestore.Update{
+			{Path: "status", Value: "sending"},
+			{Path: "claimed_at", Value: firestore.ServerTimestamp},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	sender, err := notifierFor(n.Channel)
+	if err == nil {
+		err = sendWithRetry(ctx, sender, n)
+	}
+	if err == nil {
+		_, err = ref.Update(ctx, []firestore.Update{
+			{Path: "status", Value: "sent"},
+			{Path: "attempts", Value: attempts + 1},
+			{Path: "sent_at", Value: firestore.ServerTimestamp},
+		})
+		return err
+	}
+
+	attempts++
+	log.Printf("Failed to deliver notification %s (attempt %d): %v", ref.ID, attempts, err)
+	var perm *permanentError
+	if attempts >= maxDeliveryAttempts || errors.As(err, &perm) {
+		_, uerr := ref.Update(ctx, []firestore.Update{
+			{Path: "status", Value: "failed"},
+			{Path: "attempts", Value: attempts},
+			{Path: "last_error", Value: err.Error()},
+		})
+		if uerr != nil {
+			log.Printf("Failed to mark notification %s failed: %v", ref.ID, uerr)
+		}
+		return err
+	}
+
+	wait := deliveryRetryBaseWait << (attempts - 1)
+	_, uerr := ref.Update(ctx, []firestore.Update{
+		{Path: "status", Value: "queued"},
+		{Path: "attempts", Value: attempts},
+		{Path: "last_error", Value: err.Error()},
+		{Path: "deliver_after", Value: time.Now().Add(wait)},
+	})
+	if uerr != nil {
+		log.Printf("Failed to requeue notification %s: %v", ref.ID, uerr)
+	}
+	return err
+}
+
+// dispatchNotificationsHandler sends every email / sms notification that is
+// due. Meant to be hit by Cloud Scheduler every few minutes.
+func dispatchNotificationsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	deadline := time.Now().Add(dispatchTimeBudget)
+	docs, err := firestoreClient.CollectionGroup("notifications").
+		Where("status", "==", "queued").
+		Where("channel", "in", []string{"email", "sms"}).
+		Where("deliver_after", "<=", time.Now()).
+		OrderBy("deliver_after", firestore.Asc).
+		Limit(dispatchBatchSize).
+		Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to query notifications: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	sent, failed := 0, 0
+	for _, doc := range docs {
+		if time.Now().After(deadline) {
+			break
+		}
+		err := deliverNotification(ctx, doc.Ref)
+		switch {
+		case err == nil:
+			sent++
+		case errors.Is(err, errNotificationClaimed):
+		default:
+			failed++
+		}
+	}
+
+	writeJSON(w, map[string]int{
+		"sent":   sent,
+		"failed": failed,
+	})
+	return nil
+}
@@ -0,0 +1,114 @@
+package group
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// smtpNotifier sends email through an SMTP relay.
+//
+//	SMTP_HOST, SMTP_PORT (default 587), SMTP_FROM, SMTP_USERNAME, SMTP_PASSWORD
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func newSMTPNotifier() (*smtpNotifier, error) {
+	env, err := requireEnv("SMTP_HOST", "SMTP_FROM")
+	if err != nil {
+		return nil, err
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	n := &smtpNotifier{
+		addr: net.JoinHostPort(env["SMTP_HOST"], port),
+		from: env["SMTP_FROM"],
+	}
+	if user := os.Getenv("SMTP_USERNAME"); user != "" {
+		n.auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), env["SMTP_HOST"])
+	}
+	return n, nil
+}
+
+func (s *smtpNotifier) Send(ctx context.Context, n notification) error {
+	if strings.ContainsAny(n.To, "\r\n") || strings.ContainsAny(n.Title, "\r\n") {
+		return &permanentError{fmt.Errorf("invalid header value")}
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", n.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Title)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	msg.WriteString(n.Body)
+
+	if err := smtp.SendMail(s.addr, s.auth, s.from, []string{n.To}, msg.Bytes()); err != nil {
+		// 5xx replies are permanent (bad mailbox, rejected sender)
+		if strings.HasPrefix(err.Error(), "5") {
+			return &permanentError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// sendGridNotifier sends email through a SendGrid style v3 mail/send API.
+//
+//	SENDGRID_API_KEY, SENDGRID_FROM, SENDGRID_API_URL (optional)
+type sendGridNotifier struct {
+	url    string
+	apiKey string
+	from   string
+}
+
+func newSendGridNotifier() (*sendGridNotifier, error) {
+	env, err := requireEnv("SENDGRID_API_KEY", "SENDGRID_FROM")
+	if err != nil {
+		return nil, err
+	}
+	url := os.Getenv("SENDGRID_API_URL")
+	if url == "" {
+		url = "https://api.sendgrid.com/v3/mail/send"
+	}
+	return &sendGridNotifier{url: url, apiKey: env["SENDGRID_API_KEY"], from: env["SENDGRID_FROM"]}, nil
+}
+
+func (s *sendGridNotifier) Send(ctx context.Context, n notification) error {
+	payload := map[string]interface{}{
+		"personalizations": []map[string]interface{}{
+			{"to": []map[string]string{{"email": n.To}}},
+		},
+		"from":    map[string]string{"email": s.from},
+		"subject": n.Title,
+		"content": []map[string]string{{"type": "text/plain", "value": n.Body}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &permanentError{err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkHTTPResponse(resp)
+}
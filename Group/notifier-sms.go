@@ -0,0 +1,58 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// twilioNotifier sends text messages through a Twilio style Messages API.
+//
+//	TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, TWILIO_FROM, TWILIO_API_URL (optional)
+type twilioNotifier struct {
+	endpoint   string
+	accountSID string
+	authToken  string
+	from       string
+}
+
+func newTwilioNotifier() (*twilioNotifier, error) {
+	env, err := requireEnv("TWILIO_ACCOUNT_SID", "TWILIO_AUTH_TOKEN", "TWILIO_FROM")
+	if err != nil {
+		return nil, err
+	}
+	base := os.Getenv("TWILIO_API_URL")
+	if base == "" {
+		base = "https://api.twilio.com"
+	}
+	return &twilioNotifier{
+		endpoint:   fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", strings.TrimSuffix(base, "/"), env["TWILIO_ACCOUNT_SID"]),
+		accountSID: env["TWILIO_ACCOUNT_SID"],
+		authToken:  env["TWILIO_AUTH_TOKEN"],
+		from:       env["TWILIO_FROM"],
+	}, nil
+}
+
+func (t *twilioNotifier) Send(ctx context.Context, n notification) error {
+	form := url.Values{}
+	form.Set("To", n.To)
+	form.Set("From", t.from)
+	form.Set("Body", n.Body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.SetBasicAuth(t.accountSID, t.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkHTTPResponse(resp)
+}
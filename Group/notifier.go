@@ -0,0 +1,208 @@
+package group
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+	Outbound delivery for email and sms notifications.
+
+	Which implementation is used comes from the environment:
+
+	EMAIL_NOTIFIER   smtp | sendgrid | log
+	SMS_NOTIFIER     twilio | log
+
+	The log notifier writes one JSON line per message, recipient and body
+	included, to NOTIFIER_LOG_FILE or stdout if that isn't set. It is for
+	local runs and tests, so it has to be asked for. With nothing set the
+	channel isn't configured: a warning is logged when the notifiers are
+	first set up and messages on it fail without being written anywhere.
+*/
+
+// notifier delivers a single message on one channel.
+type notifier interface {
+	Send(ctx context.Context, n notification) error
+}
+
+// permanentError marks a failure that retrying won't fix, e.g. a rejected
+// address or bad credentials.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+const (
+	sendAttempts  = 3
+	sendBaseDelay = 500 * time.Millisecond
+)
+
+var (
+	notifiersOnce sync.Once
+	emailNotifier notifier
+	smsNotifier   notifier
+	notifiersErr  error
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifierFor returns the configured notifier for a channel.
+func notifierFor(channel string) (notifier, error) {
+	notifiersOnce.Do(func() {
+		emailNotifier, notifiersErr = newEmailNotifier(os.Getenv("EMAIL_NOTIFIER"))
+		if notifiersErr != nil {
+			return
+		}
+		smsNotifier, notifiersErr = newSMSNotifier(os.Getenv("SMS_NOTIFIER"))
+	})
+	if notifiersErr != nil {
+		return nil, &permanentError{notifiersErr}
+	}
+
+	switch channel {
+	case "email":
+		return emailNotifier, nil
+	case "sms":
+		return smsNotifier, nil
+	default:
+		return nil, &permanentError{fmt.Errorf("no notifier for channel %q", channel)}
+	}
+}
+
+// sendWithRetry tries n.Send a few times with exponential backoff and
+// jitter, giving up early on permanent errors.
+func sendWithRetry(ctx context.Context, n notifier, msg notification) error {
+	delay := sendBaseDelay
+	var err error
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		if err = n.Send(ctx, msg); err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt == sendAttempts {
+			break
+		}
+
+		wait := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+	return err
+}
+
+// checkHTTPResponse turns a provider response into an error. 429 and 5xx
+// are worth retrying, any other non-2xx status is permanent.
+func checkHTTPResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err := fmt.Errorf("provider returned %s: %s", resp.Status, body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return err
+	}
+	return &permanentError{err}
+}
+
+// logNotifier writes messages as JSON lines instead of sending them.
+type logNotifier struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newLogNotifier() (*logNotifier, error) {
+	path := os.Getenv("NOTIFIER_LOG_FILE")
+	if path == "" {
+		return &logNotifier{out: os.Stdout}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open NOTIFIER_LOG_FILE: %w", err)
+	}
+	return &logNotifier{out: f}, nil
+}
+
+func (l *logNotifier) Send(ctx context.Context, n notification) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return json.NewEncoder(l.out).Encode(map[string]string{
+		"sent_at": time.Now().UTC().Format(time.RFC3339),
+		"type":    n.Type,
+		"channel": n.Channel,
+		"to":      n.To,
+		"title":   n.Title,
+		"body":    n.Body,
+	})
+}
+
+// unconfiguredNotifier stands in for a channel with no notifier set. It
+// fails every message so they end up "failed" in the outbox instead of
+// leaking addresses and message bodies into the function's logs.
+type unconfiguredNotifier struct {
+	env string
+}
+
+func newUnconfiguredNotifier(env string) *unconfiguredNotifier {
+	log.Printf("Warning: %s is not set; notifications on this channel won't be delivered", env)
+	return &unconfiguredNotifier{env: env}
+}
+
+func (u *unconfiguredNotifier) Send(ctx context.Context, n notification) error {
+	return &permanentError{fmt.Errorf("%s is not set", u.env)}
+}
+
+func newEmailNotifier(kind string) (notifier, error) {
+	switch kind {
+	case "":
+		return newUnconfiguredNotifier("EMAIL_NOTIFIER"), nil
+	case "log":
+		return newLogNotifier()
+	case "smtp":
+		return newSMTPNotifier()
+	case "sendgrid":
+		return newSendGridNotifier()
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_NOTIFIER %q", kind)
+	}
+}
+
+func newSMSNotifier(kind string) (notifier, error) {
+	switch kind {
+	case "":
+		return newUnconfiguredNotifier("SMS_NOTIFIER"), nil
+	case "log":
+		return newLogNotifier()
+	case "twilio":
+		return newTwilioNotifier()
+	default:
+		return nil, fmt.Errorf("unknown SMS_NOTIFIER %q", kind)
+	}
+}
+
+// requireEnv reads the named variables, failing if any are empty.
+func requireEnv(names ...string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		v := os.Getenv(name)
+		if v == "" {
+			return nil, fmt.Errorf("%s must be set", name)
+		}
+		values[name] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,99 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Fixed window rate limits, one doc per key:
+
+	/rate_limits/{key}
+		window_start, count, expire_at
+
+	expire_at is set so a Firestore TTL policy can clean up old windows.
+*/
+
+// takeRateLimit counts one hit against key. If the key already has limit
+// hits in the current window it returns how long until the window resets,
+// otherwise 0.
+func takeRateLimit(ctx context.Context, key string, limit int, window time.Duration) (time.Duration, error) {
+	ref := firestoreClient.Collection("rate_limits").Doc(key)
+
+	var retryAfter time.Duration
+	err := firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		retryAfter = 0
+		now := time.Now()
+
+		snap, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if err == nil {
+			data := snap.Data()
+			start, _ := data["window_start"].(time.Time)
+			count, _ := data["count"].(int64)
+			if now.Sub(start) < window {
+				if count >= int64(limit) {
+					retryAfter = start.Add(window).Sub(now)
+					return nil
+				}
+				return tx.Update(ref, []firestore.Update{
+					{Path: "count", Value: count + 1},
+				})
+			}
+		}
+
+		// No window yet, or the last one is over
+		return tx.Set(ref, map[string]interface{}{
+			"window_start": now,
+			"count":        1,
+			"expire_at":    now.Add(window),
+		})
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to check rate limit %s: %w", key, err)
+	}
+	return retryAfter, nil
+}
+
+// writeRateLimited answers 429 with a Retry-After header in whole seconds.
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	secs := int(math.Ceil(retryAfter.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// clientIP is the caller's address, for limits that can't trust anything in
+// the request body. Google's front end appends the address it saw to
+// X-Forwarded-For, so the last entry is the one a client can't forge.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		parts := strings.Split(fwd, ",")
+		if ip := net.ParseIP(strings.TrimSpace(parts[len(parts)-1])); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
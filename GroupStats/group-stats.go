@@ -0,0 +1,277 @@
+package groupstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // groups can pick any IANA timezone
+
+	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var firestoreClient *firestore.Client
+
+/*
+	Keeps the denormalized counters on groups/{groupId}.stats up to date:
+
+	stats: {
+		member_count,           groups/{groupId}/members
+		invite_count,           users/{uid}/group_invites and /invites with status "pending"
+		open_chore_count,       groups/{groupId}/chores still to do
+		overdue_chore_count,    open chores whose chore_due_date has passed
+		completed_chore_count,  chores with chore_status "completed"
+	}
+
+	Chores that are "completed" or "skipped" are closed, as in the chore
+	function's isClosed, and ones "pending verification" have been done and
+	are only waiting for a check, so neither counts as open.
+
+	Each trigger works out how its document moved the counters (new - old)
+	and applies that with firestore.Increment. Events can be delivered more
+	than once, so every event ID is recorded in stats_events and skipped if
+	it was already applied.
+
+	Overdue changes with the clock rather than with writes, so the triggers
+	leave overdue_chore_count alone: a chore that became overdue since it
+	was last written would be taken off without ever having been added.
+	RecomputeGroupStatsHandler sets it, by the group's timezone, and should
+	run on a daily schedule.
+
+	The triggers expect the event data as JSON
+	(--event-data-content-type=application/json), see README.md.
+*/
+
+const statsEventTTL = 7 * 24 * time.Hour
+
+// documentValue is a Firestore document in the JSON form Eventarc sends.
+type documentValue struct {
+	Name   string                    `json:"name"`
+	Fields map[string]firestoreValue `json:"fields"`
+}
+
+type firestoreValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+}
+
+// documentEventData is the payload of a google.cloud.firestore.document.v1.written event.
+type documentEventData struct {
+	OldValue *documentValue `json:"oldValue,omitempty"`
+	Value    *documentValue `json:"value,omitempty"`
+}
+
+func (d *documentValue) str(field string) string {
+	if d == nil {
+		return ""
+	}
+	if v, ok := d.Fields[field]; ok && v.StringValue != nil {
+		return *v.StringValue
+	}
+	return ""
+}
+
+func decodeDocumentEvent(e event.Event) (documentEventData, error) {
+	var data documentEventData
+	if err := json.Unmarshal(e.Data(), &data); err != nil {
+		return data, fmt.Errorf("failed to decode firestore event %s: %w", e.ID(), err)
+	}
+	return data, nil
+}
+
+// docPath returns the document path relative to the database root,
+// e.g. groups/abc/members/uid.
+func (d documentEventData) docPath() string {
+	name := ""
+	if d.Value != nil {
+		name = d.Value.Name
+	} else if d.OldValue != nil {
+		name = d.OldValue.Name
+	}
+	if i := strings.Index(name, "/documents/"); i >= 0 {
+		return name[i+len("/documents/"):]
+	}
+	return name
+}
+
+// pathSegmentAfter returns the segment that follows collection in path.
+func pathSegmentAfter(path string, collection string) string {
+	parts := strings.Split(path, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == collection {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+func exists(d *documentValue) int64 {
+	if d == nil {
+		return 0
+	}
+	return 1
+}
+
+// closedStatuses are the chore_status values of chores that are done
+// with; the same set as the chore function's isClosed.
+var closedStatuses = map[string]bool{
+	"completed": true,
+	"skipped":   true,
+}
+
+// choreCounters returns how one chore counts towards the open and
+// completed chore stats. Overdue is left to the recompute, see isOverdue.
+// completed_chore_count is the chores that are finished for good, which
+// only one-off chores ever are: completing a recurring chore moves it to
+// its next due date as "not started", so those completions are only in
+// its history.
+func choreCounters(choreStatus string) map[string]int64 {
+	counts := map[string]int64{
+		"open_chore_count":      0,
+		"completed_chore_count": 0,
+	}
+	switch {
+	case choreStatus == "completed":
+		counts["completed_chore_count"] = 1
+	case closedStatuses[choreStatus], choreStatus == "pending verification":
+	default:
+		counts["open_chore_count"] = 1
+	}
+	return counts
+}
+
+// isOverdue reports whether an open chore's due date is before today,
+// both YYYY-MM-DD in the group's timezone.
+func isOverdue(choreStatus string, dueDate string, today string) bool {
+	if choreCounters(choreStatus)["open_chore_count"] == 0 {
+		return false
+	}
+	// chore_due_date is stored as YYYY-MM-DD, so string order is date order
+	return len(dueDate) >= 10 && dueDate[:10] < today
+}
+
+func inviteCounters(d *documentValue) map[string]int64 {
+	if d != nil && d.str("status") == "pending" {
+		return map[string]int64{"invite_count": 1}
+	}
+	return map[string]int64{"invite_count": 0}
+}
+
+// applyStatsDelta increments groups/{groupID}.stats by delta exactly once per event.
+func applyStatsDelta(ctx context.Context, eventID string, groupID string, delta map[string]int64) error {
+	var updates []firestore.Update
+	for field, n := range delta {
+		if n != 0 {
+			updates = append(updates, firestore.Update{Path: "stats." + field, Value: firestore.Increment(n)})
+		}
+	}
+	if groupID == "" || len(updates) == 0 {
+		return nil
+	}
+
+	groupRef := firestoreClient.Collection("groups").Doc(groupID)
+	markerRef := firestoreClient.Collection("stats_events").Doc(eventID)
+
+	return firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(markerRef); err == nil {
+			log.Printf("Stats event %s already applied, skipping", eventID)
+			return nil
+		} else if status.Code(err) != codes.NotFound {
+			return fmt.Errorf("failed reading stats event marker: %w", err)
+		}
+
+		// Don't resurrect a group that was purged while its subcollections
+		// were being deleted.
+		if _, err := tx.Get(groupRef); status.Code(err) == codes.NotFound {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed reading group %s: %w", groupID, err)
+		}
+
+		if err := tx.Update(groupRef, updates); err != nil {
+			return fmt.Errorf("failed to update stats for group %s: %w", groupID, err)
+		}
+		return tx.Create(markerRef, map[string]interface{}{
+			"group_id":   groupID,
+			"applied_at": firestore.ServerTimestamp,
+			"expire_at":  time.Now().Add(statsEventTTL),
+		})
+	})
+}
+
+// OnMemberWritten fires on groups/{groupId}/members/{uid}.
+func OnMemberWritten(ctx context.Context, e event.Event) error {
+	data, err := decodeDocumentEvent(e)
+	if err != nil {
+		return err
+	}
+	groupID := pathSegmentAfter(data.docPath(), "groups")
+	delta := map[string]int64{"member_count": exists(data.Value) - exists(data.OldValue)}
+	return applyStatsDelta(ctx, e.ID(), groupID, delta)
+}
+
+// OnInviteWritten fires on users/{uid}/group_invites/{groupId}.
+func OnInviteWritten(ctx context.Context, e event.Event) error {
+	data, err := decodeDocumentEvent(e)
+	if err != nil {
+		return err
+	}
+	groupID := data.Value.str("group_id")
+	if groupID == "" {
+		groupID = data.OldValue.str("group_id")
+	}
+	after, before := inviteCounters(data.Value), inviteCounters(data.OldValue)
+	delta := map[string]int64{"invite_count": after["invite_count"] - before["invite_count"]}
+	return applyStatsDelta(ctx, e.ID(), groupID, delta)
+}
+
+// OnContactInviteWritten fires on invites/{inviteId}, the invites parked
+// for people who don't have an account yet.
+func OnContactInviteWritten(ctx context.Context, e event.Event) error {
+	return OnInviteWritten(ctx, e)
+}
+
+// OnChoreWritten fires on groups/{groupId}/chores/{choreId}.
+func OnChoreWritten(ctx context.Context, e event.Event) error {
+	data, err := decodeDocumentEvent(e)
+	if err != nil {
+		return err
+	}
+	groupID := pathSegmentAfter(data.docPath(), "groups")
+
+	delta := map[string]int64{}
+	if data.Value != nil {
+		for k, n := range choreCounters(data.Value.str("chore_status")) {
+			delta[k] += n
+		}
+	}
+	if data.OldValue != nil {
+		for k, n := range choreCounters(data.OldValue.str("chore_status")) {
+			delta[k] -= n
+		}
+	}
+	return applyStatsDelta(ctx, e.ID(), groupID, delta)
+}
+
+func init() {
+	ctx := context.Background()
+	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+
+	var err error
+	firestoreClient, err = firestore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to initialize Firestore client: %v", err)
+	}
+
+	functions.CloudEvent("OnMemberWritten", OnMemberWritten)
+	functions.CloudEvent("OnInviteWritten", OnInviteWritten)
+	functions.CloudEvent("OnContactInviteWritten", OnContactInviteWritten)
+	functions.CloudEvent("OnChoreWritten", OnChoreWritten)
+	functions.HTTP("RecomputeGroupStatsHandler", RecomputeGroupStatsHandler)
+}
@@ -0,0 +1,223 @@
+package groupstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const recomputeTimeBudget = 45 * time.Second
+
+// countQuery runs a COUNT aggregation over q.
+func countQuery(ctx context.Context, q firestore.Query) (int64, error) {
+	res, err := q.NewAggregationQuery().WithCount("n").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := res["n"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result %T", res["n"])
+	}
+	return v.GetIntegerValue(), nil
+}
+
+// groupLocation is the group's settings.timezone, or UTC.
+func groupLocation(groupSnap *firestore.DocumentSnapshot) *time.Location {
+	if tz, err := groupSnap.DataAt("settings.timezone"); err == nil {
+		if name, _ := tz.(string); name != "" {
+			if loc, err := time.LoadLocation(name); err == nil {
+				return loc
+			}
+		}
+	}
+	return time.UTC
+}
+
+// recomputeGroupStats counts everything from scratch and overwrites stats.
+// It is the only place overdue_chore_count is set.
+func recomputeGroupStats(ctx context.Context, groupSnap *firestore.DocumentSnapshot) (map[string]int64, error) {
+	groupRef := groupSnap.Ref
+	stats := map[string]int64{
+		"member_count":          0,
+		"invite_count":          0,
+		"open_chore_count":      0,
+		"overdue_chore_count":   0,
+		"completed_chore_count": 0,
+	}
+
+	members, err := countQuery(ctx, groupRef.Collection("members").Query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count members: %w", err)
+	}
+	stats["member_count"] = members
+
+	invites, err := countQuery(ctx, firestoreClient.CollectionGroup("group_invites").
+		Where("group_id", "==", groupRef.ID).
+		Where("status", "==", "pending"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count invites: %w", err)
+	}
+	stats["invite_count"] = invites
+
+	contactInvites, err := countQuery(ctx, firestoreClient.Collection("invites").
+		Where("group_id", "==", groupRef.ID).
+		Where("status", "==", "pending"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count contact invites: %w", err)
+	}
+	stats["invite_count"] += contactInvites
+
+	today := time.Now().In(groupLocation(groupSnap)).Format("2006-01-02")
+	iter := groupRef.Collection("chores").Select("chore_status", "chore_due_date").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read chores: %w", err)
+		}
+		choreStatus, _ := doc.Data()["chore_status"].(string)
+		dueDate, _ := doc.Data()["chore_due_date"].(string)
+		for k, n := range choreCounters(choreStatus) {
+			stats[k] += n
+		}
+		if isOverdue(choreStatus, dueDate, today) {
+			stats["overdue_chore_count"]++
+		}
+	}
+
+	updates := []firestore.Update{{Path: "stats.recomputed_at", Value: firestore.ServerTimestamp}}
+	for field, n := range stats {
+		updates = append(updates, firestore.Update{Path: "stats." + field, Value: n})
+	}
+	if _, err := groupRef.Update(ctx, updates); err != nil {
+		return nil, fmt.Errorf("failed to save stats: %w", err)
+	}
+	return stats, nil
+}
+
+// recomputeCursor remembers where the last all-groups walk stopped, so a
+// scheduled run that carries the same empty body every time still gets
+// through every group.
+func recomputeCursor() *firestore.DocumentRef {
+	return firestoreClient.Collection("stats_jobs").Doc("recompute")
+}
+
+// RecomputeGroupStatsHandler repairs drift in groups/{groupId}.stats.
+// POST {"group_id": "..."} fixes one group. Otherwise it walks the groups
+// in ID order until the time budget runs out, starting after start_after
+// or, without one, where the previous walk stopped; it answers done and
+// the next group ID to start after (run it from Cloud Scheduler).
+func RecomputeGroupStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed; only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		GroupID    string `json:"group_id"`
+		StartAfter string `json:"start_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.GroupID != "" {
+		groupSnap, err := firestoreClient.Collection("groups").Doc(req.GroupID).Get(ctx)
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, fmt.Sprintf("Group %s not found", req.GroupID), http.StatusNotFound)
+			return
+		}
+		var stats map[string]int64
+		if err == nil {
+			stats, err = recomputeGroupStats(ctx, groupSnap)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error recomputing stats for group %s: %v", req.GroupID, err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"group_id": req.GroupID,
+			"stats":    stats,
+		})
+		return
+	}
+
+	startAfter := req.StartAfter
+	if startAfter == "" {
+		snap, err := recomputeCursor().Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			http.Error(w, fmt.Sprintf("Error reading the recompute cursor: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			startAfter, _ = snap.Data()["next"].(string)
+		}
+	}
+
+	deadline := time.Now().Add(recomputeTimeBudget)
+	var recomputed, failed []string
+	query := firestoreClient.Collection("groups").OrderBy(firestore.DocumentID, firestore.Asc)
+	if startAfter != "" {
+		query = query.StartAfter(startAfter)
+	}
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+	next := startAfter
+	done := false
+	for time.Now().Before(deadline) {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			done = true
+			break
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing groups: %v", err), http.StatusInternalServerError)
+			return
+		}
+		next = doc.Ref.ID
+		if _, deleted := doc.Data()["deleted_at"]; deleted {
+			continue
+		}
+		if _, err := recomputeGroupStats(ctx, doc); err != nil {
+			log.Printf("Failed to recompute stats for group %s: %v", doc.Ref.ID, err)
+			failed = append(failed, doc.Ref.ID)
+			continue
+		}
+		recomputed = append(recomputed, doc.Ref.ID)
+	}
+	if done {
+		// The next walk starts from the beginning
+		next = ""
+	}
+	if _, err := recomputeCursor().Set(ctx, map[string]interface{}{
+		"next":       next,
+		"updated_at": firestore.ServerTimestamp,
+	}); err != nil {
+		log.Printf("Failed to save the recompute cursor: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recomputed": recomputed,
+		"failed":     failed,
+		"done":       done,
+		"next":       next,
+	})
+}
@@ -0,0 +1,664 @@
+package lists
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	Items on a list.
+
+	Adding an item that's already on the list and not bought yet, with the
+	same unit, adds to its quantity instead of making a second line, so two
+	people adding "milk" get "milk x2". Checking an item off records who
+	bought it; unchecking puts it back on the list, and clearing deletes
+	everything that's checked off. Checked items still count against
+	maxListItems until they're cleared. The list's item_count and
+	open_count are kept up to date in the same transaction.
+*/
+
+const (
+	maxItemNameLength = 100
+	maxUnitLength     = 20
+	maxCategoryLength = 30
+	maxNotesLength    = 500
+	maxQuantity       = 9999
+	maxListItems      = 500
+	defaultCategory   = "other"
+)
+
+var (
+	errAlreadyChecked = errors.New("item is already checked off")
+	errNotChecked     = errors.New("item isn't checked off")
+	errTooManyItems   = errors.New("the list has too many items")
+	errQuantityTooBig = errors.New("quantity is too big")
+)
+
+// itemView is one item as the API returns it.
+type itemView struct {
+	ID       string  `json:"item_id"`
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit,omitempty"`
+	Category string  `json:"category"`
+	Notes    string  `json:"notes,omitempty"`
+	Checked  bool    `json:"checked"`
+	AddedBy  string  `json:"added_by,omitempty"`
+	AddedAt  string  `json:"added_at,omitempty"`
+	BoughtBy string  `json:"bought_by,omitempty"`
+	BoughtAt string  `json:"bought_at,omitempty"`
+}
+
+func itemViewFromSnapshot(snap *firestore.DocumentSnapshot) itemView {
+	data := snap.Data()
+	v := itemView{ID: snap.Ref.ID}
+	v.Name, _ = data["name"].(string)
+	v.Quantity = quantityOf(data)
+	v.Unit, _ = data["unit"].(string)
+	v.Category, _ = data["category"].(string)
+	v.Notes, _ = data["notes"].(string)
+	v.Checked, _ = data["checked"].(bool)
+	v.AddedBy, _ = data["added_by"].(string)
+	v.BoughtBy, _ = data["bought_by"].(string)
+	if t, ok := data["added_at"].(time.Time); ok {
+		v.AddedAt = t.UTC().Format(time.RFC3339)
+	}
+	if t, ok := data["bought_at"].(time.Time); ok {
+		v.BoughtAt = t.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+// quantityOf reads an item's quantity, which Firestore hands back as an
+// int64 for whole numbers written by other clients.
+func quantityOf(data map[string]interface{}) float64 {
+	switch q := data["quantity"].(type) {
+	case float64:
+		return q
+	case int64:
+		return float64(q)
+	}
+	return 1
+}
+
+// nameKey is what two item names have to share to be the same item.
+func nameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// normalizeCategory lower cases and trims a category; empty is "other".
+func normalizeCategory(category string) (string, error) {
+	c := strings.ToLower(strings.TrimSpace(category))
+	if c == "" {
+		return defaultCategory, nil
+	}
+	if len(c) > maxCategoryLength {
+		return "", fmt.Errorf("category can be at most %d characters", maxCategoryLength)
+	}
+	return c, nil
+}
+
+// itemFields are the parts of an item a member sets.
+type itemFields struct {
+	Name     *string  `json:"name"`
+	Quantity *float64 `json:"quantity"` // default 1
+	Unit     *string  `json:"unit"`     // e.g. "kg", "packs"
+	Category *string  `json:"category"` // default "other"
+	Notes    *string  `json:"notes"`
+}
+
+// check trims the fields that were sent and checks their limits.
+func (f *itemFields) check() error {
+	if f.Name != nil {
+		name := strings.TrimSpace(*f.Name)
+		if name == "" || len(name) > maxItemNameLength {
+			return fmt.Errorf("name is required and can be at most %d characters", maxItemNameLength)
+		}
+		f.Name = &name
+	}
+	if f.Quantity != nil && (*f.Quantity <= 0 || *f.Quantity > maxQuantity) {
+		return fmt.Errorf("quantity must be more than 0 and at most %d", maxQuantity)
+	}
+	if f.Unit != nil {
+		unit := strings.TrimSpace(*f.Unit)
+		if len(unit) > maxUnitLength {
+			return fmt.Errorf("unit can be at most %d characters", maxUnitLength)
+		}
+		f.Unit = &unit
+	}
+	if f.Category != nil {
+		category, err := normalizeCategory(*f.Category)
+		if err != nil {
+			return err
+		}
+		f.Category = &category
+	}
+	if f.Notes != nil && len(*f.Notes) > maxNotesLength {
+		return fmt.Errorf("notes can be at most %d characters", maxNotesLength)
+	}
+	return nil
+}
+
+func writeItemError(w http.ResponseWriter, err error) error {
+	code := statusForError(err)
+	switch {
+	case errors.Is(err, errAlreadyChecked), errors.Is(err, errNotChecked), errors.Is(err, errTooManyItems):
+		code = http.StatusConflict
+	case errors.Is(err, errQuantityTooBig):
+		code = http.StatusBadRequest
+	}
+	http.Error(w, fmt.Sprintf("Failed to update list: %v", err), code)
+	return err
+}
+
+// readList reads the list in tx, mapping a missing one to errListNotFound.
+func readList(tx *firestore.Transaction, listRef *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	snap, err := tx.Get(listRef)
+	if status.Code(err) == codes.NotFound {
+		return nil, errListNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read list %s: %w", listRef.ID, err)
+	}
+	return snap, nil
+}
+
+// readItem reads the item in tx, mapping a missing one to errItemNotFound.
+func readItem(tx *firestore.Transaction, itemRef *firestore.DocumentRef) (*firestore.DocumentSnapshot, error) {
+	snap, err := tx.Get(itemRef)
+	if status.Code(err) == codes.NotFound {
+		return nil, errItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read item %s: %w", itemRef.ID, err)
+	}
+	return snap, nil
+}
+
+// listCounts updates the list's counts and updated_at.
+func listCounts(tx *firestore.Transaction, listRef *firestore.DocumentRef, items int, open int) error {
+	updates := []firestore.Update{
+		{Path: "updated_at", Value: firestore.ServerTimestamp},
+	}
+	if items != 0 {
+		updates = append(updates, firestore.Update{Path: "item_count", Value: firestore.Increment(items)})
+	}
+	if open != 0 {
+		updates = append(updates, firestore.Update{Path: "open_count", Value: firestore.Increment(open)})
+	}
+	return tx.Update(listRef, updates)
+}
+
+// listItemsHandler lists a list's items: still to buy first, then by
+// category and name. checked and category narrow it down.
+//
+//	GET /groups/{groupId}/lists/{listId}/items?user_id=...&checked=true|false&category=...
+func listItemsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	userID := query.Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, listID := r.PathValue("groupId"), r.PathValue("listId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list items: %v", err), statusForError(err))
+		return err
+	}
+	listRef := groupSnap.Ref.Collection("lists").Doc(listID)
+	listSnap, err := listRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		err = errListNotFound
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list items: %v", err), statusForError(err))
+		return err
+	}
+
+	q := listRef.Collection("items").Query
+	switch checked := query.Get("checked"); checked {
+	case "":
+	case "true", "false":
+		q = q.Where("checked", "==", checked == "true")
+	default:
+		http.Error(w, "checked must be true or false", http.StatusBadRequest)
+		return fmt.Errorf("invalid checked %q", checked)
+	}
+	if category := query.Get("category"); category != "" {
+		c, err := normalizeCategory(category)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+		q = q.Where("category", "==", c)
+	}
+	docs, err := q.Limit(maxListItems).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list items: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	items := make([]itemView, 0, len(docs))
+	for _, doc := range docs {
+		items = append(items, itemViewFromSnapshot(doc))
+	}
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.Checked != b.Checked {
+			return !a.Checked
+		}
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		return nameKey(a.Name) < nameKey(b.Name)
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"list":  listViewFromSnapshot(listSnap),
+		"items": items,
+	})
+	return nil
+}
+
+// addItemHandler puts an item on the list, or adds to the quantity of the
+// same item if it's already there and not bought yet.
+//
+//	POST /groups/{groupId}/lists/{listId}/items {"user_id", "name", "quantity", "unit", "category", "notes"}
+func addItemHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		itemFields
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" || req.Name == nil {
+		http.Error(w, "user_id and name are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	if err := req.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	quantity, unit, category, notes := 1.0, "", defaultCategory, ""
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+	if req.Unit != nil {
+		unit = *req.Unit
+	}
+	if req.Category != nil {
+		category = *req.Category
+	}
+	if req.Notes != nil {
+		notes = *req.Notes
+	}
+	key := nameKey(*req.Name)
+	groupID, listID := r.PathValue("groupId"), r.PathValue("listId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to add item: %v", err), statusForError(err))
+		return err
+	}
+	listRef := groupSnap.Ref.Collection("lists").Doc(listID)
+	items := listRef.Collection("items")
+
+	var itemID string
+	var merged bool
+	var total float64
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		merged = false
+		listSnap, err := readList(tx, listRef)
+		if err != nil {
+			return err
+		}
+		same, err := tx.Documents(items.Where("name_key", "==", key).Where("checked", "==", false)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to read items: %w", err)
+		}
+
+		for _, doc := range same {
+			if u, _ := doc.Data()["unit"].(string); !strings.EqualFold(u, unit) {
+				continue
+			}
+			itemID, merged = doc.Ref.ID, true
+			total = quantityOf(doc.Data()) + quantity
+			if total > maxQuantity {
+				return fmt.Errorf("%w: %s would go over %d", errQuantityTooBig, *req.Name, maxQuantity)
+			}
+			if err := tx.Update(doc.Ref, []firestore.Update{
+				{Path: "quantity", Value: total},
+				{Path: "updated_at", Value: firestore.ServerTimestamp},
+			}); err != nil {
+				return err
+			}
+			return listCounts(tx, listRef, 0, 0)
+		}
+
+		if count, _ := listSnap.Data()["item_count"].(int64); count >= maxListItems {
+			return fmt.Errorf("%w (at most %d; clear the checked off ones to make room)", errTooManyItems, maxListItems)
+		}
+		ref := items.NewDoc()
+		itemID, total = ref.ID, quantity
+		if err := tx.Create(ref, map[string]interface{}{
+			"name":       *req.Name,
+			"name_key":   key,
+			"quantity":   quantity,
+			"unit":       unit,
+			"category":   category,
+			"notes":      notes,
+			"checked":    false,
+			"added_by":   req.UserID,
+			"added_at":   firestore.ServerTimestamp,
+			"updated_at": firestore.ServerTimestamp,
+		}); err != nil {
+			return err
+		}
+		return listCounts(tx, listRef, 1, 1)
+	})
+	if err != nil {
+		return writeItemError(w, err)
+	}
+
+	message := fmt.Sprintf("Added %s", *req.Name)
+	if merged {
+		message = fmt.Sprintf("%s was already on the list; quantity is now %g", *req.Name, total)
+	}
+	writeJSON(w, map[string]interface{}{
+		"message":  message,
+		"item_id":  itemID,
+		"merged":   merged,
+		"quantity": total,
+	})
+	return nil
+}
+
+// updateItemHandler edits an item. Only the fields sent are changed.
+//
+//	PATCH /groups/{groupId}/lists/{listId}/items/{itemId} {"user_id", "name", "quantity", "unit", "category", "notes"}
+func updateItemHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		itemFields
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	if err := req.check(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+
+	var updates []firestore.Update
+	if req.Name != nil {
+		updates = append(updates,
+			firestore.Update{Path: "name", Value: *req.Name},
+			firestore.Update{Path: "name_key", Value: nameKey(*req.Name)},
+		)
+	}
+	if req.Quantity != nil {
+		updates = append(updates, firestore.Update{Path: "quantity", Value: *req.Quantity})
+	}
+	if req.Unit != nil {
+		updates = append(updates, firestore.Update{Path: "unit", Value: *req.Unit})
+	}
+	if req.Category != nil {
+		updates = append(updates, firestore.Update{Path: "category", Value: *req.Category})
+	}
+	if req.Notes != nil {
+		updates = append(updates, firestore.Update{Path: "notes", Value: *req.Notes})
+	}
+	if len(updates) == 0 {
+		http.Error(w, "Nothing to update; send name, quantity, unit, category or notes", http.StatusBadRequest)
+		return fmt.Errorf("no item fields to update")
+	}
+	updates = append(updates, firestore.Update{Path: "updated_at", Value: firestore.ServerTimestamp})
+	groupID, listID, itemID := r.PathValue("groupId"), r.PathValue("listId"), r.PathValue("itemId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update item: %v", err), statusForError(err))
+		return err
+	}
+	listRef := groupSnap.Ref.Collection("lists").Doc(listID)
+	itemRef := listRef.Collection("items").Doc(itemID)
+
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := readList(tx, listRef); err != nil {
+			return err
+		}
+		if _, err := readItem(tx, itemRef); err != nil {
+			return err
+		}
+		if err := tx.Update(itemRef, updates); err != nil {
+			return err
+		}
+		return listCounts(tx, listRef, 0, 0)
+	})
+	if err != nil {
+		return writeItemError(w, err)
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("Item %s updated", itemID),
+		"item_id": itemID,
+	})
+	return nil
+}
+
+// checkItemHandler checks an item off as bought by the caller.
+//
+//	POST /groups/{groupId}/lists/{listId}/items/{itemId}/check {"user_id"}
+func checkItemHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return setChecked(ctx, w, r, true)
+}
+
+// uncheckItemHandler puts a checked off item back on the list.
+//
+//	POST /groups/{groupId}/lists/{listId}/items/{itemId}/uncheck {"user_id"}
+func uncheckItemHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return setChecked(ctx, w, r, false)
+}
+
+func setChecked(ctx context.Context, w http.ResponseWriter, r *http.Request, checked bool) error {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, listID, itemID := r.PathValue("groupId"), r.PathValue("listId"), r.PathValue("itemId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to update item: %v", err), statusForError(err))
+		return err
+	}
+	listRef := groupSnap.Ref.Collection("lists").Doc(listID)
+	itemRef := listRef.Collection("items").Doc(itemID)
+
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := readList(tx, listRef); err != nil {
+			return err
+		}
+		snap, err := readItem(tx, itemRef)
+		if err != nil {
+			return err
+		}
+		was, _ := snap.Data()["checked"].(bool)
+		if checked && was {
+			return errAlreadyChecked
+		}
+		if !checked && !was {
+			return errNotChecked
+		}
+
+		updates := []firestore.Update{
+			{Path: "checked", Value: checked},
+			{Path: "updated_at", Value: firestore.ServerTimestamp},
+		}
+		open := 1
+		if checked {
+			updates = append(updates,
+				firestore.Update{Path: "bought_by", Value: req.UserID},
+				firestore.Update{Path: "bought_at", Value: firestore.ServerTimestamp},
+			)
+			open = -1
+		} else {
+			updates = append(updates,
+				firestore.Update{Path: "bought_by", Value: firestore.Delete},
+				firestore.Update{Path: "bought_at", Value: firestore.Delete},
+			)
+		}
+		if err := tx.Update(itemRef, updates); err != nil {
+			return err
+		}
+		return listCounts(tx, listRef, 0, open)
+	})
+	if err != nil {
+		return writeItemError(w, err)
+	}
+
+	message := fmt.Sprintf("Item %s checked off", itemID)
+	if !checked {
+		message = fmt.Sprintf("Item %s is back on the list", itemID)
+	}
+	writeJSON(w, map[string]interface{}{
+		"message": message,
+		"item_id": itemID,
+		"checked": checked,
+	})
+	return nil
+}
+
+// deleteItemHandler takes an item off the list. Any member can.
+//
+//	DELETE /groups/{groupId}/lists/{listId}/items/{itemId}?user_id=...
+func deleteItemHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, listID, itemID := r.PathValue("groupId"), r.PathValue("listId"), r.PathValue("itemId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete item: %v", err), statusForError(err))
+		return err
+	}
+	listRef := groupSnap.Ref.Collection("lists").Doc(listID)
+	itemRef := listRef.Collection("items").Doc(itemID)
+
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := readList(tx, listRef); err != nil {
+			return err
+		}
+		snap, err := readItem(tx, itemRef)
+		if err != nil {
+			return err
+		}
+		open := -1
+		if checked, _ := snap.Data()["checked"].(bool); checked {
+			open = 0
+		}
+		if err := tx.Delete(itemRef); err != nil {
+			return err
+		}
+		return listCounts(tx, listRef, -1, open)
+	})
+	if err != nil {
+		return writeItemError(w, err)
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("Item %s deleted", itemID),
+		"item_id": itemID,
+	})
+	return nil
+}
+
+// clearCheckedHandler deletes every checked off item on the list, so
+// bought items stop taking up room. Any member can.
+//
+//	POST /groups/{groupId}/lists/{listId}/clear-checked {"user_id"}
+func clearCheckedHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, listID := r.PathValue("groupId"), r.PathValue("listId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to clear items: %v", err), statusForError(err))
+		return err
+	}
+	listRef := groupSnap.Ref.Collection("lists").Doc(listID)
+
+	// A list holds at most maxListItems, so one transaction can take them
+	// all and keep item_count right
+	var cleared int
+	err = firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := readList(tx, listRef); err != nil {
+			return err
+		}
+		docs, err := tx.Documents(listRef.Collection("items").Where("checked", "==", true)).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to read checked items: %w", err)
+		}
+		cleared = len(docs)
+		if cleared == 0 {
+			return nil
+		}
+		for _, doc := range docs {
+			if err := tx.Delete(doc.Ref); err != nil {
+				return err
+			}
+		}
+		return listCounts(tx, listRef, -cleared, 0)
+	})
+	if err != nil {
+		return writeItemError(w, err)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"message": fmt.Sprintf("Cleared %d checked off items", cleared),
+		"list_id": listID,
+		"cleared": cleared,
+	})
+	return nil
+}
@@ -0,0 +1,356 @@
+package lists
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+	ListHandler serves the group's shared shopping lists. They sit next to
+	the chores, in the same groups/{groupId} doc:
+
+	/groups/{groupId}/lists/{listId}
+		name, created_by, created_at, updated_at,
+		item_count, open_count (items not bought yet)
+
+	/groups/{groupId}/lists/{listId}/items/{itemId}
+		name, name_key (lower case, for spotting duplicates), quantity, unit,
+		category, notes, checked, added_by, added_at,
+		bought_by, bought_at (checked items), updated_at
+
+	Only the group's owner and members can see or change them. Routes are
+	matched by method and path like the Group and chore functions; see
+	README.md for the list.
+*/
+
+const (
+	maxListNameLength = 100
+	maxGroupLists     = 50
+)
+
+var firestoreClient *firestore.Client
+
+var (
+	errGroupNotFound  = errors.New("group not found")
+	errNotGroupMember = errors.New("user is not a member of this group")
+	errGroupDeleted   = errors.New("group has been deleted")
+	errListNotFound   = errors.New("list not found")
+	errItemNotFound   = errors.New("item not found")
+	errTooManyLists   = errors.New("the group has too many lists")
+	errCantDeleteList = errors.New("only whoever created the list or the group owner can delete it")
+)
+
+// handler adapts a list handler to the mux. They write their own error
+// responses, so the returned error is only logged.
+func handler(h func(context.Context, http.ResponseWriter, *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := h(r.Context(), w, r); err != nil {
+			log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+}
+
+// newRouter maps every list endpoint by method and path.
+func newRouter() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Lists
+	mux.HandleFunc("POST /groups/{groupId}/lists", handler(createListHandler))
+	mux.HandleFunc("GET /groups/{groupId}/lists", handler(listListsHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/lists/{listId}", handler(deleteListHandler))
+
+	// Items
+	mux.HandleFunc("GET /groups/{groupId}/lists/{listId}/items", handler(listItemsHandler))
+	mux.HandleFunc("POST /groups/{groupId}/lists/{listId}/items", handler(addItemHandler))
+	mux.HandleFunc("PATCH /groups/{groupId}/lists/{listId}/items/{itemId}", handler(updateItemHandler))
+	mux.HandleFunc("POST /groups/{groupId}/lists/{listId}/items/{itemId}/check", handler(checkItemHandler))
+	mux.HandleFunc("POST /groups/{groupId}/lists/{listId}/items/{itemId}/uncheck", handler(uncheckItemHandler))
+	mux.HandleFunc("DELETE /groups/{groupId}/lists/{listId}/items/{itemId}", handler(deleteItemHandler))
+	mux.HandleFunc("POST /groups/{groupId}/lists/{listId}/clear-checked", handler(clearCheckedHandler))
+
+	return mux
+}
+
+var router = newRouter()
+
+// ListHandler is the function entry point.
+func ListHandler(w http.ResponseWriter, r *http.Request) {
+	router.ServeHTTP(w, r)
+}
+
+// requireGroupMember loads the group and checks that uid is the owner or
+// has a doc in its members subcollection. Groups that have been deleted
+// (deleted_at set by the Group function) are gone for everyone.
+func requireGroupMember(ctx context.Context, groupID string, uid string) (*firestore.DocumentSnapshot, error) {
+	groupRef := firestoreClient.Collection("groups").Doc(groupID)
+	snap, err := groupRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errGroupNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read group %s: %w", groupID, err)
+	}
+	if _, deleted := snap.Data()["deleted_at"]; deleted {
+		return nil, errGroupDeleted
+	}
+	if owner, _ := snap.Data()["created_by"].(string); owner != "" && owner == uid {
+		return snap, nil
+	}
+	if _, err := groupRef.Collection("members").Doc(uid).Get(ctx); err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, errNotGroupMember
+		}
+		return nil, fmt.Errorf("failed to read membership for %s: %w", uid, err)
+	}
+	return snap, nil
+}
+
+// statusForError maps the shared list errors onto HTTP status codes.
+func statusForError(err error) int {
+	switch {
+	case errors.Is(err, errGroupDeleted):
+		return http.StatusGone
+	case errors.Is(err, errGroupNotFound), errors.Is(err, errListNotFound), errors.Is(err, errItemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotGroupMember), errors.Is(err, errCantDeleteList):
+		return http.StatusForbidden
+	case errors.Is(err, errTooManyLists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// listView is one list as the API returns it.
+type listView struct {
+	ID        string `json:"list_id"`
+	Name      string `json:"name"`
+	CreatedBy string `json:"created_by,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+	ItemCount int64  `json:"item_count"`
+	OpenCount int64  `json:"open_count"`
+}
+
+func listViewFromSnapshot(snap *firestore.DocumentSnapshot) listView {
+	data := snap.Data()
+	v := listView{ID: snap.Ref.ID}
+	v.Name, _ = data["name"].(string)
+	v.CreatedBy, _ = data["created_by"].(string)
+	v.ItemCount, _ = data["item_count"].(int64)
+	v.OpenCount, _ = data["open_count"].(int64)
+	if t, ok := data["created_at"].(time.Time); ok {
+		v.CreatedAt = t.UTC().Format(time.RFC3339)
+	}
+	if t, ok := data["updated_at"].(time.Time); ok {
+		v.UpdatedAt = t.UTC().Format(time.RFC3339)
+	}
+	return v
+}
+
+// createListHandler adds a list to the group.
+//
+//	POST /groups/{groupId}/lists {"user_id", "name"}
+func createListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		Name   string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse request body: %v", err), http.StatusBadRequest)
+		return err
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.UserID == "" || req.Name == "" {
+		http.Error(w, "user_id and name are required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	if len(req.Name) > maxListNameLength {
+		http.Error(w, fmt.Sprintf("name can be at most %d characters", maxListNameLength), http.StatusBadRequest)
+		return fmt.Errorf("list name too long")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, req.UserID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create list: %v", err), statusForError(err))
+		return err
+	}
+	lists := groupSnap.Ref.Collection("lists")
+	existing, err := lists.Select().Limit(maxGroupLists).Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create list: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if len(existing) >= maxGroupLists {
+		http.Error(w, fmt.Sprintf("Failed to create list: %v (at most %d)", errTooManyLists, maxGroupLists), statusForError(errTooManyLists))
+		return errTooManyLists
+	}
+
+	ref, _, err := lists.Add(ctx, map[string]interface{}{
+		"name":       req.Name,
+		"created_by": req.UserID,
+		"created_at": firestore.ServerTimestamp,
+		"updated_at": firestore.ServerTimestamp,
+		"item_count": 0,
+		"open_count": 0,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create list: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("List %s created", req.Name),
+		"list_id": ref.ID,
+	})
+	return nil
+}
+
+// listListsHandler lists the group's lists with how many items are still
+// to buy, by name.
+//
+//	GET /groups/{groupId}/lists?user_id=...
+func listListsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID := r.PathValue("groupId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list lists: %v", err), statusForError(err))
+		return err
+	}
+	docs, err := groupSnap.Ref.Collection("lists").Documents(ctx).GetAll()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list lists: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	result := make([]listView, 0, len(docs))
+	for _, doc := range docs {
+		result = append(result, listViewFromSnapshot(doc))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Name) < strings.ToLower(result[j].Name)
+	})
+
+	writeJSON(w, map[string]interface{}{
+		"group_id": groupID,
+		"lists":    result,
+	})
+	return nil
+}
+
+// deleteListHandler removes a list and its items. Whoever created it and
+// the group owner can.
+//
+//	DELETE /groups/{groupId}/lists/{listId}?user_id=...
+func deleteListHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return fmt.Errorf("missing required fields")
+	}
+	groupID, listID := r.PathValue("groupId"), r.PathValue("listId")
+
+	groupSnap, err := requireGroupMember(ctx, groupID, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete list: %v", err), statusForError(err))
+		return err
+	}
+	listRef := groupSnap.Ref.Collection("lists").Doc(listID)
+	snap, err := listRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		err = errListNotFound
+	}
+	if err == nil {
+		owner, _ := groupSnap.Data()["created_by"].(string)
+		if by, _ := snap.Data()["created_by"].(string); by != userID && owner != userID {
+			err = errCantDeleteList
+		}
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete list: %v", err), statusForError(err))
+		return err
+	}
+
+	// Items first, so a failure part way leaves the list to try again
+	if err := deleteAll(ctx, listRef.Collection("items").Query); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete list: %v", err), http.StatusInternalServerError)
+		return err
+	}
+	if _, err := listRef.Delete(ctx); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete list: %v", err), http.StatusInternalServerError)
+		return err
+	}
+
+	writeJSON(w, map[string]string{
+		"message": fmt.Sprintf("List %s deleted", listID),
+		"list_id": listID,
+	})
+	return nil
+}
+
+// deleteAll deletes everything q matches, a few hundred docs at a time.
+func deleteAll(ctx context.Context, q firestore.Query) error {
+	for {
+		docs, err := q.Limit(300).Documents(ctx).GetAll()
+		if err != nil {
+			return fmt.Errorf("failed to query documents to delete: %w", err)
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		bw := firestoreClient.BulkWriter(ctx)
+		jobs := make([]*firestore.BulkWriterJob, 0, len(docs))
+		for _, doc := range docs {
+			job, err := bw.Delete(doc.Ref)
+			if err != nil {
+				bw.End()
+				return fmt.Errorf("failed to queue delete of %s: %w", doc.Ref.Path, err)
+			}
+			jobs = append(jobs, job)
+		}
+		bw.End()
+
+		for _, job := range jobs {
+			if _, err := job.Results(); err != nil {
+				return fmt.Errorf("failed to delete document: %w", err)
+			}
+		}
+	}
+}
+
+func init() {
+	ctx := context.Background()
+	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	var err error
+	firestoreClient, err = firestore.NewClient(ctx, projectID)
+	if err != nil {
+		log.Fatalf("Failed to initialize Firestore client: %v", err)
+	}
+	functions.HTTP("ListHandler", ListHandler)
+}
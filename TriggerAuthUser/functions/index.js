@@ -9,7 +9,8 @@ exports.createUserProfile = functions.auth.user().onCreate(async (user) => {
 
     const userDoc = {
         uid: user.uid,
-        email: user.email || null,
+        email: user.email ? user.email.toLowerCase() : null,
+        phone_number: user.phoneNumber || null,
         created_at: new Date(),
         role: "user"
     };